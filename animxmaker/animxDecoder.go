@@ -0,0 +1,192 @@
+package animxmaker
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+// animxReader reads the primitives EncodeAnimation writes
+type animxReader struct {
+	data []byte
+	pos  int
+}
+
+func (r *animxReader) readBytes(n int) ([]byte, error) {
+	if n < 0 || len(r.data)-r.pos < n {
+		return nil, io.ErrUnexpectedEOF
+	}
+	b := r.data[r.pos : r.pos+n]
+	r.pos += n
+	return b, nil
+}
+
+func (r *animxReader) readByte() (byte, error) {
+	b, err := r.readBytes(1)
+	if err != nil {
+		return 0, err
+	}
+	return b[0], nil
+}
+
+// read7BitEncodedInt is the inverse of write7BitEncodedInt
+func (r *animxReader) read7BitEncodedInt() (int, error) {
+	var n int
+	for shift := 0; shift < 35; shift += 7 {
+		b, err := r.readByte()
+		if err != nil {
+			return 0, err
+		}
+		n |= int(b&0x7F) << shift
+		if b&0x80 == 0 {
+			return n, nil
+		}
+	}
+	return 0, errors.New("7-bit encoded int is too long")
+}
+
+// readString is the inverse of encodeAnimString. Value strings start with a
+// byte telling whether they are set; unset strings are read as "".
+func (r *animxReader) readString(isValue bool) (string, error) {
+	if isValue {
+		hasValue, err := r.readByte()
+		if err != nil {
+			return "", err
+		}
+		if hasValue == 0 {
+			return "", nil
+		}
+	}
+	length, err := r.read7BitEncodedInt()
+	if err != nil {
+		return "", err
+	}
+	b, err := r.readBytes(length)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+func (r *animxReader) readInt32() (int32, error) {
+	b, err := r.readBytes(4)
+	if err != nil {
+		return 0, err
+	}
+	return int32(binary.LittleEndian.Uint32(b)), nil
+}
+
+func (r *animxReader) readFloat32() (float32, error) {
+	b, err := r.readBytes(4)
+	if err != nil {
+		return 0, err
+	}
+	return math.Float32frombits(binary.LittleEndian.Uint32(b)), nil
+}
+
+// decodeKeyframes reads a track's keyframes using readValue for their values
+func decodeKeyframes[T any](r *animxReader, node string, property string, readValue func() (T, error)) (*AnimationTrack[T], error) {
+	count, err := r.read7BitEncodedInt()
+	if err != nil {
+		return nil, err
+	}
+	track := &AnimationTrack[T]{
+		Node:      node,
+		Property:  property,
+		Keyframes: make([]KeyFrame[T], 0, min(count, len(r.data)-r.pos)),
+	}
+	for i := 0; i < count; i++ {
+		position, err := r.readFloat32()
+		if err != nil {
+			return nil, err
+		}
+		value, err := readValue()
+		if err != nil {
+			return nil, err
+		}
+		track.Keyframes = append(track.Keyframes, KeyFrame[T]{Position: position, Value: value})
+	}
+	return track, nil
+}
+
+func (r *animxReader) decodeTrack() (AnimationTrackWrapper, error) {
+	trackType, err := r.readByte()
+	if err != nil {
+		return nil, err
+	}
+	valueType, err := r.readByte()
+	if err != nil {
+		return nil, err
+	}
+	node, err := r.readString(false)
+	if err != nil {
+		return nil, err
+	}
+	property, err := r.readString(false)
+	if err != nil {
+		return nil, err
+	}
+	vt, ok := valueTypesByCode[valueType]
+	if !ok {
+		return nil, fmt.Errorf("unsupported value type %d", valueType)
+	}
+	return vt.decodeTrack(r, trackType, node, property)
+}
+
+// DecodeAnimation parses data written by EncodeAnimation and returns the
+// animation along with its name. Discrete tracks are decoded to an
+// *AnimationTrack, the other track types to an *RawAnimationTrack,
+// *CurveAnimationTrack or *BezierAnimationTrack, each of the Go type first
+// registered for their value type, e.g. int32 for int tracks. Tracks encoded without keyframes come back with the single
+// placeholder keyframe EncodeTrack writes for them.
+func DecodeAnimation(data []byte) (*Animation, string, error) {
+	r := &animxReader{data: data}
+	magic, err := r.readString(false)
+	if err != nil {
+		return nil, "", err
+	}
+	if magic != "AnimX" {
+		return nil, "", errors.New("not an AnimX animation")
+	}
+	version, err := r.readInt32()
+	if err != nil {
+		return nil, "", err
+	}
+	if version != 1 {
+		return nil, "", fmt.Errorf("unsupported AnimX version %d", version)
+	}
+	trackCount, err := r.read7BitEncodedInt()
+	if err != nil {
+		return nil, "", err
+	}
+	// The duration is derived from the tracks again when encoding
+	if _, err := r.readFloat32(); err != nil {
+		return nil, "", err
+	}
+	name, err := r.readString(false)
+	if err != nil {
+		return nil, "", err
+	}
+	encoding, err := r.readByte()
+	if err != nil {
+		return nil, "", err
+	}
+	if encoding != EncodingTypeBinary {
+		return nil, "", fmt.Errorf("unsupported encoding type %d", encoding)
+	}
+
+	animation := &Animation{}
+	for i := 0; i < trackCount; i++ {
+		track, err := r.decodeTrack()
+		if err != nil {
+			return nil, "", fmt.Errorf("track %d: %w", i, err)
+		}
+		animation.Tracks = append(animation.Tracks, track)
+	}
+	if r.pos != len(r.data) {
+		return nil, "", fmt.Errorf("%d trailing bytes after the last track", len(r.data)-r.pos)
+	}
+	return animation, name, nil
+}
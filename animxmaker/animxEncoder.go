@@ -3,7 +3,6 @@ package animxmaker
 import (
 	"bytes"
 	"encoding/binary"
-	"errors"
 )
 
 func write7BitEncodedInt(n int) []byte {
@@ -46,21 +45,21 @@ type KeyFrame[T any] struct {
 
 func (a *KeyFrame[T]) EncodeKeyframe() ([]byte, error){
 	var buf bytes.Buffer
-	binary.Write(&buf, binary.LittleEndian, a.Position)
-	switch v := any(a.Value).(type) {
-			case string:
-				buf.Write(encodeAnimString(v, true))
-			case int32:
-				binary.Write(&buf, binary.LittleEndian, v)
-			case float32:
-				binary.Write(&buf, binary.LittleEndian, v)
-			default:
-				return nil, errors.New("Unsupported type")
-			// pain
-		}		
+	vt, err := valueTypeOf[T]()
+	if err != nil {
+		return nil, err
+	}
+	if err := a.writeKeyframe(&buf, vt); err != nil {
+		return nil, err
+	}
 	return buf.Bytes(), nil
 }
 
+func (a *KeyFrame[T]) writeKeyframe(w animxWriter, vt *valueType) error {
+	binary.Write(w, binary.LittleEndian, a.Position)
+	return vt.encode(w, a.Value)
+}
+
 type AnimationTrackWrapper interface {
 	EncodeTrack() ([]byte, error)
 	GetTrackDuration() float32
@@ -80,79 +79,40 @@ func (a *AnimationTrack[T]) GetTrackDuration() float32 {
 }
 
 func (a *AnimationTrack[T]) EncodeTrack() ([]byte, error){
-
 	var buf bytes.Buffer
-	buf.WriteByte(1) // track type
+	err := a.writeTrack(&buf)
+	return buf.Bytes(), err
+}
+
+func (a *AnimationTrack[T]) writeTrack(w animxWriter) error {
 	if len(a.Keyframes) == 0 {
 		// If there's no keyframes we need to write a dummy keyframe in order to prevent resontie from crashing
-		buf.WriteByte(39) // 39 = String
-		buf.Write(encodeAnimString(a.Node, false))
-		buf.Write(encodeAnimString(a.Property, false))
-		binary.Write(&buf, binary.LittleEndian, write7BitEncodedInt(1)) // keyframe count
-		keyframe := KeyFrame[string]{
-			Position: 0,
-			Value: "No results",
-		}
-		keyframeBytes, err := keyframe.EncodeKeyframe()
-		if err != nil {
-			return nil, err
-		}
-		buf.Write(keyframeBytes)
-		return buf.Bytes(), nil
+		return writePlaceholderTrack(w, a.Node, a.Property)
 	}
-	switch any(a.Keyframes[0].Value).(type) {
-		case string:
-			buf.WriteByte(39) // 39 = String
-		case int32:
-			buf.WriteByte(10) // 10 = Int
-		case float32:
-			buf.WriteByte(21) // 21 = Float
-		default:
-			return nil, errors.New("Unsupported type")
-
-		// pain again
+	vt, err := valueTypeOf[T]()
+	if err != nil {
+		return err
 	}
-	buf.Write(encodeAnimString(a.Node, false))
-	buf.Write(encodeAnimString(a.Property, false))
-	binary.Write(&buf, binary.LittleEndian, write7BitEncodedInt(len(a.Keyframes))) // keyframe count
+	writeTrackHeader(w, TrackTypeDiscrete, vt.code, a.Node, a.Property)
+	w.Write(write7BitEncodedInt(len(a.Keyframes))) // keyframe count
 	for _, keyframe := range a.Keyframes{
-		var keyframeBytes []byte
-		keyframeBytes, err := keyframe.EncodeKeyframe()
-		if err != nil {
-			return nil, err
+		if err := keyframe.writeKeyframe(w, vt); err != nil {
+			return err
 		}
-		buf.Write(keyframeBytes)
 	}
-	return buf.Bytes(), nil
+	return nil
 }
 
 type Animation struct {
 	Tracks []AnimationTrackWrapper
 }
 
+// EncodeAnimation returns the whole animation encoded. Use an Encoder to
+// write it out without holding it in memory.
 func (a *Animation) EncodeAnimation(animationName string) ([]byte, error) {
 	var buf bytes.Buffer
-
-	buf.Write(encodeAnimString("AnimX", false))             // "Magic Word"
-	binary.Write(&buf, binary.LittleEndian, int32(1))	// Version
-	buf.Write(write7BitEncodedInt(len(a.Tracks)))           // Track count
-	
-	var maxDuration float32 = 0
-	for _, track := range a.Tracks {
-		if track.GetTrackDuration() > maxDuration {
-			maxDuration = track.GetTrackDuration()
-		}
-	}
-	binary.Write(&buf, binary.LittleEndian, maxDuration) 	// Max duration
-	buf.Write(encodeAnimString(animationName, false))     	// Animation name
-	buf.WriteByte(0)                     			// Encoding type (0 is default binary reader)
-	for _, track := range a.Tracks {
-		trackBytes, err := track.EncodeTrack()
-		if err != nil {
-			return nil, err
-		}
-		buf.Write(trackBytes)
+	if err := NewEncoder(&buf).Encode(a, animationName); err != nil {
+		return nil, err
 	}
-	
 	return buf.Bytes(), nil
 }
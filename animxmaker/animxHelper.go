@@ -15,3 +15,83 @@ func ListTrack[T any](list []T, nodeName string, propertyName string) AnimationT
 		Property: propertyName,
 	}
 }
+
+// RawListTrack samples list at a fixed interval in a raw track
+func RawListTrack[T any](list []T, interval float32, nodeName string, propertyName string) RawAnimationTrack[T] {
+	return RawAnimationTrack[T]{
+		Node:     nodeName,
+		Property: propertyName,
+		Interval: interval,
+		Values:   list,
+	}
+}
+
+// LinearTrack is a curve track moving in straight lines between keyframes
+func LinearTrack[T any](keyframes []KeyFrame[T], nodeName string, propertyName string) CurveAnimationTrack[T] {
+	var curveKeyframes []CurveKeyFrame[T]
+	for _, keyframe := range keyframes {
+		curveKeyframes = append(curveKeyframes, CurveKeyFrame[T]{
+			Position:      keyframe.Position,
+			Value:         keyframe.Value,
+			Interpolation: InterpolationLinear,
+		})
+	}
+	return CurveTrack(curveKeyframes, nodeName, propertyName)
+}
+
+// CurveTrack is a curve track with the given keyframes
+func CurveTrack[T any](keyframes []CurveKeyFrame[T], nodeName string, propertyName string) CurveAnimationTrack[T] {
+	return CurveAnimationTrack[T]{
+		Keyframes: keyframes,
+		Node:      nodeName,
+		Property:  propertyName,
+	}
+}
+
+// BezierTrack is a bezier track with the given keyframes
+func BezierTrack[T any](keyframes []BezierKeyFrame[T], nodeName string, propertyName string) BezierAnimationTrack[T] {
+	return BezierAnimationTrack[T]{
+		Keyframes: keyframes,
+		Node:      nodeName,
+		Property:  propertyName,
+	}
+}
+
+// StatusNode is the node holding a response's status tracks
+const StatusNode = "status"
+
+// CountProperty is the property of the track holding a node's number of
+// entries. Tracks can't be empty, so an empty list is encoded as a single
+// placeholder keyframe and only its count tells it apart from one entry.
+const CountProperty = "count"
+
+// TotalProperty and NextCursorProperty are the properties of the tracks
+// describing a paginated list: the number of entries across all pages and
+// the cursor to pass for the next page, empty on the last one.
+const (
+	TotalProperty      = "total"
+	NextCursorProperty = "nextCursor"
+)
+
+// StatusTracks describes the outcome of a request in the status node: the
+// HTTP style status code, a short machine readable error code and a message
+// for the user. Successful responses use status 200 with code "ok".
+func StatusTracks(status int, code string, message string) []AnimationTrackWrapper {
+	statusTrack := ListTrack([]int32{int32(status)}, StatusNode, "status")
+	codeTrack := ListTrack([]string{code}, StatusNode, "code")
+	messageTrack := ListTrack([]string{message}, StatusNode, "message")
+	return []AnimationTrackWrapper{&statusTrack, &codeTrack, &messageTrack}
+}
+
+// CountTrack holds the number of entries listed in a node
+func CountTrack(count int, nodeName string) AnimationTrack[int32] {
+	return ListTrack([]int32{int32(count)}, nodeName, CountProperty)
+}
+
+// PageTracks holds the total number of entries and the next page cursor of a
+// paginated node
+func PageTracks(total int, nextCursor string, nodeName string) []AnimationTrackWrapper {
+	totalTrack := ListTrack([]int32{int32(total)}, nodeName, TotalProperty)
+	cursorTrack := ListTrack([]string{nextCursor}, nodeName, NextCursorProperty)
+	return []AnimationTrackWrapper{&totalTrack, &cursorTrack}
+}
@@ -0,0 +1,335 @@
+package animxmaker
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"reflect"
+)
+
+// placeholderValue is the value of the keyframe written for empty tracks
+const placeholderValue = "No results"
+
+// valueLister is implemented by every track type so decoded tracks can be
+// read without knowing their value type
+type valueLister interface {
+	trackName() (node string, property string)
+	trackValues() []any
+}
+
+func (a *AnimationTrack[T]) trackName() (string, string) { return a.Node, a.Property }
+
+func (a *AnimationTrack[T]) trackValues() []any {
+	values := make([]any, len(a.Keyframes))
+	for i, keyframe := range a.Keyframes {
+		values[i] = keyframe.Value
+	}
+	return values
+}
+
+func (a *RawAnimationTrack[T]) trackName() (string, string) { return a.Node, a.Property }
+
+func (a *RawAnimationTrack[T]) trackValues() []any {
+	values := make([]any, len(a.Values))
+	for i, value := range a.Values {
+		values[i] = value
+	}
+	return values
+}
+
+func (a *CurveAnimationTrack[T]) trackName() (string, string) { return a.Node, a.Property }
+
+func (a *CurveAnimationTrack[T]) trackValues() []any {
+	values := make([]any, len(a.Keyframes))
+	for i, keyframe := range a.Keyframes {
+		values[i] = keyframe.Value
+	}
+	return values
+}
+
+func (a *BezierAnimationTrack[T]) trackName() (string, string) { return a.Node, a.Property }
+
+func (a *BezierAnimationTrack[T]) trackValues() []any {
+	values := make([]any, len(a.Keyframes))
+	for i, keyframe := range a.Keyframes {
+		values[i] = keyframe.Value
+	}
+	return values
+}
+
+// valuesTrack is a discrete track built by Marshal, whose value type is only
+// known at runtime. It encodes exactly like the equivalent ListTrack.
+type valuesTrack struct {
+	node     string
+	property string
+	vt       *valueType
+	values   []any
+}
+
+func (a *valuesTrack) trackName() (string, string) { return a.node, a.property }
+
+func (a *valuesTrack) trackValues() []any { return a.values }
+
+func (a *valuesTrack) GetTrackDuration() float32 {
+	if len(a.values) == 0 {
+		return 0
+	}
+	return float32(len(a.values) - 1)
+}
+
+func (a *valuesTrack) EncodeTrack() ([]byte, error) {
+	var buf bytes.Buffer
+	err := a.writeTrack(&buf)
+	return buf.Bytes(), err
+}
+
+func (a *valuesTrack) writeTrack(w animxWriter) error {
+	if len(a.values) == 0 {
+		return writePlaceholderTrack(w, a.node, a.property)
+	}
+	writeTrackHeader(w, TrackTypeDiscrete, a.vt.code, a.node, a.property)
+	w.Write(write7BitEncodedInt(len(a.values)))
+	for i, value := range a.values {
+		binary.Write(w, binary.LittleEndian, float32(i))
+		if err := a.vt.encode(w, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// structField is a struct field that maps to a track
+type structField struct {
+	index    []int
+	node     string
+	property string
+	goType   reflect.Type
+	vt       *valueType
+}
+
+// structFields lists the tracks a struct type maps to. Exported fields use
+// the name from their animx tag, or the field name when untagged, and are
+// skipped when tagged "-". Fields whose type isn't a registered value type
+// must be structs and become a nested node called "<node>/<name>"; embedded
+// structs without a tag stay in the same node.
+func structFields(structType reflect.Type, node string, index []int) ([]structField, error) {
+	var fields []structField
+	for i := 0; i < structType.NumField(); i++ {
+		field := structType.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		tag := field.Tag.Get("animx")
+		if tag == "-" {
+			continue
+		}
+		name := tag
+		if name == "" {
+			name = field.Name
+		}
+		fieldIndex := append(append([]int{}, index...), i)
+		if vt, ok := valueTypesByGoType[field.Type]; ok {
+			fields = append(fields, structField{index: fieldIndex, node: node, property: name, goType: field.Type, vt: vt})
+			continue
+		}
+		if field.Type.Kind() != reflect.Struct {
+			return nil, fmt.Errorf("field %s has unsupported type %s", field.Name, field.Type)
+		}
+		childNode := node + "/" + name
+		if field.Anonymous && tag == "" {
+			childNode = node
+		}
+		nested, err := structFields(field.Type, childNode, fieldIndex)
+		if err != nil {
+			return nil, err
+		}
+		fields = append(fields, nested...)
+	}
+	return fields, nil
+}
+
+// sliceStructType returns the struct type of a slice of structs or of
+// pointers to structs
+func sliceStructType(sliceType reflect.Type) (reflect.Type, error) {
+	if sliceType.Kind() != reflect.Slice && sliceType.Kind() != reflect.Array {
+		return nil, fmt.Errorf("expected a slice, got %s", sliceType)
+	}
+	elemType := sliceType.Elem()
+	if elemType.Kind() == reflect.Pointer {
+		elemType = elemType.Elem()
+	}
+	if elemType.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("expected a slice of structs, got %s", sliceType)
+	}
+	return elemType, nil
+}
+
+// Marshal turns a slice of structs into one discrete track per field, with
+// the i-th element's values at position i. See structFields for how fields
+// map to tracks, e.g.
+//
+//	type item struct {
+//		ID   int32  `animx:"id"`
+//		Name string `animx:"name"`
+//	}
+//	tracks, err := Marshal(items, "results")
+//
+// gives the same tracks as ListTrack(ids, "results", "id") and
+// ListTrack(names, "results", "name").
+func Marshal(slice any, nodeName string) ([]AnimationTrackWrapper, error) {
+	v := reflect.ValueOf(slice)
+	if !v.IsValid() {
+		return nil, errors.New("cannot marshal nil")
+	}
+	structType, err := sliceStructType(v.Type())
+	if err != nil {
+		return nil, err
+	}
+	fields, err := structFields(structType, nodeName, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	tracks := make([]*valuesTrack, len(fields))
+	for i, field := range fields {
+		tracks[i] = &valuesTrack{
+			node:     field.node,
+			property: field.property,
+			vt:       field.vt,
+			values:   make([]any, 0, v.Len()),
+		}
+	}
+	for i := 0; i < v.Len(); i++ {
+		elem := v.Index(i)
+		if elem.Kind() == reflect.Pointer {
+			if elem.IsNil() {
+				return nil, fmt.Errorf("element %d is nil", i)
+			}
+			elem = elem.Elem()
+		}
+		for j, field := range fields {
+			tracks[j].values = append(tracks[j].values, elem.FieldByIndex(field.index).Interface())
+		}
+	}
+
+	wrappers := make([]AnimationTrackWrapper, len(tracks))
+	for i, track := range tracks {
+		wrappers[i] = track
+	}
+	return wrappers, nil
+}
+
+// isPlaceholder reports whether a track's values are the placeholder written
+// for an empty track
+func isPlaceholder(values []any) bool {
+	return len(values) == 1 && values[0] == placeholderValue
+}
+
+// Unmarshal is the counterpart of Marshal. It fills the slice out points to
+// from the tracks of an animation in nodeName and its nested nodes. Values are
+// converted to the field types where possible, so tracks decoded as int32
+// fill int fields. Fields without a track are left at their zero value. The
+// slice gets as many elements as the node's CountTrack says; without one,
+// empty tracks are recognised by their placeholder keyframe.
+func Unmarshal(animation *Animation, nodeName string, out any) error {
+	outValue := reflect.ValueOf(out)
+	if outValue.Kind() != reflect.Pointer || outValue.IsNil() || outValue.Elem().Kind() != reflect.Slice {
+		return errors.New("out must be a pointer to a slice")
+	}
+	sliceValue := outValue.Elem()
+	structType, err := sliceStructType(sliceValue.Type())
+	if err != nil {
+		return err
+	}
+	fields, err := structFields(structType, nodeName, nil)
+	if err != nil {
+		return err
+	}
+
+	tracks := make(map[string][]any)
+	count := -1
+	for _, track := range animation.Tracks {
+		lister, ok := track.(valueLister)
+		if !ok {
+			continue
+		}
+		node, property := lister.trackName()
+		values := lister.trackValues()
+		tracks[node+"\x00"+property] = values
+		if node == nodeName && property == CountProperty && len(values) == 1 {
+			if n, ok := values[0].(int32); ok {
+				count = int(n)
+			}
+		}
+	}
+	for _, field := range fields {
+		if field.node == nodeName && field.property == CountProperty {
+			count = -1
+		}
+	}
+
+	// An empty slice marshals to placeholder tracks, which only tell apart
+	// from a single "No results" string when every track is one
+	columns := make([][]any, len(fields))
+	allPlaceholders := true
+	length := 0
+	for i, field := range fields {
+		values, ok := tracks[field.node+"\x00"+field.property]
+		if !ok {
+			continue
+		}
+		columns[i] = values
+		if !isPlaceholder(values) {
+			allPlaceholders = false
+		}
+	}
+	for i, values := range columns {
+		if count >= 0 {
+			columns[i] = values[:min(count, len(values))]
+		} else if allPlaceholders || (isPlaceholder(values) && fields[i].goType.Kind() != reflect.String) {
+			columns[i] = nil
+		}
+		length = max(length, len(columns[i]))
+	}
+	if count >= 0 {
+		length = count
+	}
+
+	result := reflect.MakeSlice(sliceValue.Type(), length, length)
+	pointers := sliceValue.Type().Elem().Kind() == reflect.Pointer
+	for i := 0; i < length; i++ {
+		elem := result.Index(i)
+		if pointers {
+			elem.Set(reflect.New(structType))
+			elem = elem.Elem()
+		}
+		for j, field := range fields {
+			if i >= len(columns[j]) {
+				continue
+			}
+			value := reflect.ValueOf(columns[j][i])
+			target := elem.FieldByIndex(field.index)
+			switch {
+			case value.Type().AssignableTo(target.Type()):
+				target.Set(value)
+			case value.CanConvert(target.Type()) && isNumeric(value.Kind()) && isNumeric(target.Kind()):
+				target.Set(value.Convert(target.Type()))
+			default:
+				return fmt.Errorf("cannot store %s track %s/%s in %s field", value.Type(), field.node, field.property, target.Type())
+			}
+		}
+	}
+	sliceValue.Set(result)
+	return nil
+}
+
+func isNumeric(kind reflect.Kind) bool {
+	switch kind {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,110 @@
+package animxmaker
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// animxWriter is what tracks are written to. Both implementations used,
+// bytes.Buffer and bufio.Writer, keep the first write error and report it
+// later, so individual writes don't need checking.
+type animxWriter interface {
+	io.Writer
+	io.ByteWriter
+}
+
+// trackWriter is implemented by the tracks of this package to write
+// themselves without an intermediate buffer
+type trackWriter interface {
+	writeTrack(w animxWriter) error
+}
+
+// Encoder writes an animation to an io.Writer as it is encoded
+type Encoder struct {
+	w          *bufio.Writer
+	tracksLeft int
+	started    bool
+}
+
+// NewEncoder returns an Encoder writing to w
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{w: bufio.NewWriter(w)}
+}
+
+// Encode writes a whole animation, taking the track count and duration for
+// the header from its tracks. It gives the same bytes as EncodeAnimation.
+func (e *Encoder) Encode(a *Animation, animationName string) error {
+	var maxDuration float32 = 0
+	for _, track := range a.Tracks {
+		if track.GetTrackDuration() > maxDuration {
+			maxDuration = track.GetTrackDuration()
+		}
+	}
+	if err := e.WriteHeader(animationName, len(a.Tracks), maxDuration); err != nil {
+		return err
+	}
+	for _, track := range a.Tracks {
+		if err := e.WriteTrack(track); err != nil {
+			return err
+		}
+	}
+	return e.Close()
+}
+
+// WriteHeader starts an animation with a declared number of tracks and
+// duration, for callers that produce their tracks one at a time. Exactly
+// trackCount tracks must follow before Close; duration should be the largest
+// GetTrackDuration among them.
+func (e *Encoder) WriteHeader(animationName string, trackCount int, duration float32) error {
+	if e.started {
+		return errors.New("animation header already written")
+	}
+	if trackCount < 0 {
+		return fmt.Errorf("invalid track count %d", trackCount)
+	}
+	e.started = true
+	e.tracksLeft = trackCount
+
+	e.w.Write(encodeAnimString("AnimX", false))       // "Magic Word"
+	binary.Write(e.w, binary.LittleEndian, int32(1))  // Version
+	e.w.Write(write7BitEncodedInt(trackCount))        // Track count
+	binary.Write(e.w, binary.LittleEndian, duration)  // Max duration
+	e.w.Write(encodeAnimString(animationName, false)) // Animation name
+	e.w.WriteByte(EncodingTypeBinary)                 // Encoding type (0 is default binary reader)
+	return nil
+}
+
+// WriteTrack writes the next of the tracks declared in WriteHeader
+func (e *Encoder) WriteTrack(track AnimationTrackWrapper) error {
+	if !e.started {
+		return errors.New("animation header not written")
+	}
+	if e.tracksLeft == 0 {
+		return errors.New("more tracks written than declared")
+	}
+	e.tracksLeft--
+	if writer, ok := track.(trackWriter); ok {
+		return writer.writeTrack(e.w)
+	}
+	trackBytes, err := track.EncodeTrack()
+	if err != nil {
+		return err
+	}
+	_, err = e.w.Write(trackBytes)
+	return err
+}
+
+// Close flushes the animation to the underlying writer. It fails if fewer
+// tracks were written than declared, as the animation would be unreadable.
+func (e *Encoder) Close() error {
+	if err := e.w.Flush(); err != nil {
+		return err
+	}
+	if e.tracksLeft > 0 {
+		return fmt.Errorf("%d declared tracks were not written", e.tracksLeft)
+	}
+	return nil
+}
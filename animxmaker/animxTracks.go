@@ -0,0 +1,294 @@
+package animxmaker
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+// writeTrackHeader writes the fields every track starts with
+func writeTrackHeader(w animxWriter, trackType byte, valueType byte, node string, property string) {
+	w.WriteByte(trackType)
+	w.WriteByte(valueType)
+	w.Write(encodeAnimString(node, false))
+	w.Write(encodeAnimString(property, false))
+}
+
+// writePlaceholderTrack writes the discrete track with a single dummy
+// keyframe that stands in for tracks without keyframes, which crash Resonite
+func writePlaceholderTrack(w animxWriter, node string, property string) error {
+	writeTrackHeader(w, TrackTypeDiscrete, ValueTypeString, node, property)
+	w.Write(write7BitEncodedInt(1)) // keyframe count
+	binary.Write(w, binary.LittleEndian, float32(0))
+	w.Write(encodeAnimString(placeholderValue, true))
+	return nil
+}
+
+// interpolatedValueTypeOf is valueTypeOf for curve and bezier tracks
+func interpolatedValueTypeOf[T any]() (*valueType, error) {
+	vt, err := valueTypeOf[T]()
+	if err != nil {
+		return nil, err
+	}
+	if !vt.interpolated {
+		var zero T
+		return nil, fmt.Errorf("%T values can't be interpolated", zero)
+	}
+	return vt, nil
+}
+
+// RawAnimationTrack holds values sampled at a fixed interval, the first one
+// at position 0
+type RawAnimationTrack[T any] struct {
+	Node     string
+	Property string
+	Interval float32
+	Values   []T
+}
+
+func (a *RawAnimationTrack[T]) GetTrackDuration() float32 {
+	if len(a.Values) == 0 {
+		return 0
+	}
+	return a.Interval * float32(len(a.Values)-1)
+}
+
+// EncodeTrack writes the value count and interval followed by the values
+func (a *RawAnimationTrack[T]) EncodeTrack() ([]byte, error) {
+	var buf bytes.Buffer
+	err := a.writeTrack(&buf)
+	return buf.Bytes(), err
+}
+
+func (a *RawAnimationTrack[T]) writeTrack(w animxWriter) error {
+	if len(a.Values) == 0 {
+		return writePlaceholderTrack(w, a.Node, a.Property)
+	}
+	vt, err := valueTypeOf[T]()
+	if err != nil {
+		return err
+	}
+	writeTrackHeader(w, TrackTypeRaw, vt.code, a.Node, a.Property)
+	w.Write(write7BitEncodedInt(len(a.Values)))
+	binary.Write(w, binary.LittleEndian, a.Interval)
+	for _, value := range a.Values {
+		if err := vt.encode(w, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CurveKeyFrame is a keyframe of a curve track. Interpolation is one of the
+// Interpolation constants and decides how the curve continues towards the
+// next keyframe; the tangents are only used by InterpolationTangent and
+// InterpolationCubicBezier.
+type CurveKeyFrame[T any] struct {
+	Position      float32
+	Value         T
+	Interpolation byte
+	LeftTangent   T
+	RightTangent  T
+}
+
+// CurveAnimationTrack interpolates between its keyframes
+type CurveAnimationTrack[T any] struct {
+	Node      string
+	Property  string
+	Keyframes []CurveKeyFrame[T]
+}
+
+func (a *CurveAnimationTrack[T]) GetTrackDuration() float32 {
+	if len(a.Keyframes) == 0 {
+		return 0
+	}
+	return a.Keyframes[len(a.Keyframes)-1].Position
+}
+
+// EncodeTrack writes each keyframe as its position, interpolation, value and
+// left and right tangents
+func (a *CurveAnimationTrack[T]) EncodeTrack() ([]byte, error) {
+	var buf bytes.Buffer
+	err := a.writeTrack(&buf)
+	return buf.Bytes(), err
+}
+
+func (a *CurveAnimationTrack[T]) writeTrack(w animxWriter) error {
+	if len(a.Keyframes) == 0 {
+		return writePlaceholderTrack(w, a.Node, a.Property)
+	}
+	vt, err := interpolatedValueTypeOf[T]()
+	if err != nil {
+		return err
+	}
+	writeTrackHeader(w, TrackTypeCurve, vt.code, a.Node, a.Property)
+	w.Write(write7BitEncodedInt(len(a.Keyframes)))
+	for _, keyframe := range a.Keyframes {
+		if keyframe.Interpolation > InterpolationCubicBezier {
+			return fmt.Errorf("unknown interpolation %d", keyframe.Interpolation)
+		}
+		binary.Write(w, binary.LittleEndian, keyframe.Position)
+		w.WriteByte(keyframe.Interpolation)
+		for _, value := range []T{keyframe.Value, keyframe.LeftTangent, keyframe.RightTangent} {
+			if err := vt.encode(w, value); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// BezierKeyFrame is a keyframe of a bezier track. The curve leaves it
+// towards RightTangent and arrives at the next keyframe from its LeftTangent.
+type BezierKeyFrame[T any] struct {
+	Position     float32
+	Value        T
+	LeftTangent  T
+	RightTangent T
+}
+
+// BezierAnimationTrack follows cubic bezier segments between its keyframes
+type BezierAnimationTrack[T any] struct {
+	Node      string
+	Property  string
+	Keyframes []BezierKeyFrame[T]
+}
+
+func (a *BezierAnimationTrack[T]) GetTrackDuration() float32 {
+	if len(a.Keyframes) == 0 {
+		return 0
+	}
+	return a.Keyframes[len(a.Keyframes)-1].Position
+}
+
+// EncodeTrack writes each keyframe as its position, value and left and
+// right tangents
+func (a *BezierAnimationTrack[T]) EncodeTrack() ([]byte, error) {
+	var buf bytes.Buffer
+	err := a.writeTrack(&buf)
+	return buf.Bytes(), err
+}
+
+func (a *BezierAnimationTrack[T]) writeTrack(w animxWriter) error {
+	if len(a.Keyframes) == 0 {
+		return writePlaceholderTrack(w, a.Node, a.Property)
+	}
+	vt, err := interpolatedValueTypeOf[T]()
+	if err != nil {
+		return err
+	}
+	writeTrackHeader(w, TrackTypeBezier, vt.code, a.Node, a.Property)
+	w.Write(write7BitEncodedInt(len(a.Keyframes)))
+	for _, keyframe := range a.Keyframes {
+		binary.Write(w, binary.LittleEndian, keyframe.Position)
+		for _, value := range []T{keyframe.Value, keyframe.LeftTangent, keyframe.RightTangent} {
+			if err := vt.encode(w, value); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// decodeTrackOf reads the rest of a track of the given type whose values are
+// read with readValue
+func decodeTrackOf[T any](r *animxReader, trackType byte, node string, property string, readValue func() (T, error)) (AnimationTrackWrapper, error) {
+	switch trackType {
+	case TrackTypeRaw:
+		return decodeRawTrack(r, node, property, readValue)
+	case TrackTypeDiscrete:
+		return decodeKeyframes(r, node, property, readValue)
+	case TrackTypeCurve:
+		return decodeCurveTrack(r, node, property, readValue)
+	case TrackTypeBezier:
+		return decodeBezierTrack(r, node, property, readValue)
+	default:
+		return nil, fmt.Errorf("unsupported track type %d", trackType)
+	}
+}
+
+func decodeRawTrack[T any](r *animxReader, node string, property string, readValue func() (T, error)) (*RawAnimationTrack[T], error) {
+	count, err := r.read7BitEncodedInt()
+	if err != nil {
+		return nil, err
+	}
+	interval, err := r.readFloat32()
+	if err != nil {
+		return nil, err
+	}
+	track := &RawAnimationTrack[T]{
+		Node:     node,
+		Property: property,
+		Interval: interval,
+		Values:   make([]T, 0, min(count, len(r.data)-r.pos)),
+	}
+	for i := 0; i < count; i++ {
+		value, err := readValue()
+		if err != nil {
+			return nil, err
+		}
+		track.Values = append(track.Values, value)
+	}
+	return track, nil
+}
+
+// readTangents reads a keyframe's value followed by its two tangents
+func readTangents[T any](readValue func() (T, error)) (value T, left T, right T, err error) {
+	if value, err = readValue(); err != nil {
+		return
+	}
+	if left, err = readValue(); err != nil {
+		return
+	}
+	right, err = readValue()
+	return
+}
+
+func decodeCurveTrack[T any](r *animxReader, node string, property string, readValue func() (T, error)) (*CurveAnimationTrack[T], error) {
+	count, err := r.read7BitEncodedInt()
+	if err != nil {
+		return nil, err
+	}
+	track := &CurveAnimationTrack[T]{
+		Node:      node,
+		Property:  property,
+		Keyframes: make([]CurveKeyFrame[T], 0, min(count, len(r.data)-r.pos)),
+	}
+	for i := 0; i < count; i++ {
+		var keyframe CurveKeyFrame[T]
+		if keyframe.Position, err = r.readFloat32(); err != nil {
+			return nil, err
+		}
+		if keyframe.Interpolation, err = r.readByte(); err != nil {
+			return nil, err
+		}
+		if keyframe.Value, keyframe.LeftTangent, keyframe.RightTangent, err = readTangents(readValue); err != nil {
+			return nil, err
+		}
+		track.Keyframes = append(track.Keyframes, keyframe)
+	}
+	return track, nil
+}
+
+func decodeBezierTrack[T any](r *animxReader, node string, property string, readValue func() (T, error)) (*BezierAnimationTrack[T], error) {
+	count, err := r.read7BitEncodedInt()
+	if err != nil {
+		return nil, err
+	}
+	track := &BezierAnimationTrack[T]{
+		Node:      node,
+		Property:  property,
+		Keyframes: make([]BezierKeyFrame[T], 0, min(count, len(r.data)-r.pos)),
+	}
+	for i := 0; i < count; i++ {
+		var keyframe BezierKeyFrame[T]
+		if keyframe.Position, err = r.readFloat32(); err != nil {
+			return nil, err
+		}
+		if keyframe.Value, keyframe.LeftTangent, keyframe.RightTangent, err = readTangents(readValue); err != nil {
+			return nil, err
+		}
+		track.Keyframes = append(track.Keyframes, keyframe)
+	}
+	return track, nil
+}
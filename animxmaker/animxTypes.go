@@ -0,0 +1,173 @@
+package animxmaker
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"reflect"
+)
+
+// Float2 is Resonite's float2
+type Float2 struct {
+	X, Y float32
+}
+
+// Float3 is Resonite's float3
+type Float3 struct {
+	X, Y, Z float32
+}
+
+// Float4 is Resonite's float4
+type Float4 struct {
+	X, Y, Z, W float32
+}
+
+// FloatQ is Resonite's floatQ quaternion
+type FloatQ struct {
+	X, Y, Z, W float32
+}
+
+// DoubleQ is Resonite's doubleQ quaternion
+type DoubleQ struct {
+	X, Y, Z, W float64
+}
+
+// ColorProfile is the color space of a ColorX
+type ColorProfile byte
+
+const (
+	ColorProfileLinear    ColorProfile = 0
+	ColorProfileSRGB      ColorProfile = 1
+	ColorProfileSRGBAlpha ColorProfile = 2
+)
+
+// ColorX is Resonite's colorX, a color together with its profile
+type ColorX struct {
+	R, G, B, A float32
+	Profile    ColorProfile
+}
+
+// Uri is a URI value. An empty Uri is encoded as null.
+type Uri string
+
+// valueType describes how values of one Go type are written to and read
+// from an AnimX track
+type valueType struct {
+	code   byte
+	encode func(w animxWriter, value any) error
+	// interpolated types can be used in curve and bezier tracks
+	interpolated bool
+	// decodeTrack reads the keyframes of a track with this value type
+	decodeTrack func(r *animxReader, trackType byte, node string, property string) (AnimationTrackWrapper, error)
+}
+
+var (
+	valueTypesByGoType = map[reflect.Type]*valueType{}
+	valueTypesByCode   = map[byte]*valueType{}
+)
+
+// registerValueType makes T usable as a keyframe value. Several Go types
+// may share a code; tracks with that code decode to the first one registered.
+func registerValueType[T any](code byte, interpolated bool, encode func(w animxWriter, value T) error, decode func(r *animxReader) (T, error)) {
+	vt := &valueType{
+		code:         code,
+		interpolated: interpolated,
+		encode: func(w animxWriter, value any) error {
+			return encode(w, value.(T))
+		},
+		decodeTrack: func(r *animxReader, trackType byte, node string, property string) (AnimationTrackWrapper, error) {
+			return decodeTrackOf(r, trackType, node, property, func() (T, error) { return decode(r) })
+		},
+	}
+	valueTypesByGoType[reflect.TypeOf((*T)(nil)).Elem()] = vt
+	if _, ok := valueTypesByCode[code]; !ok {
+		valueTypesByCode[code] = vt
+	}
+}
+
+// valueTypeOf returns how values of type T are encoded
+func valueTypeOf[T any]() (*valueType, error) {
+	goType := reflect.TypeOf((*T)(nil)).Elem()
+	vt, ok := valueTypesByGoType[goType]
+	if !ok {
+		return nil, fmt.Errorf("unsupported type %s", goType)
+	}
+	return vt, nil
+}
+
+// registerFixed registers a numeric type that is written as its little
+// endian binary layout
+func registerFixed[T any](code byte) {
+	registerValueType(code, true,
+		func(w animxWriter, value T) error {
+			return binary.Write(w, binary.LittleEndian, value)
+		},
+		readFixed[T])
+}
+
+// readFixed reads a value written with binary.Write
+func readFixed[T any](r *animxReader) (T, error) {
+	var value T
+	b, err := r.readBytes(binary.Size(value))
+	if err != nil {
+		return value, err
+	}
+	err = binary.Read(bytes.NewReader(b), binary.LittleEndian, &value)
+	return value, err
+}
+
+func init() {
+	registerValueType(ValueTypeBool, false,
+		func(w animxWriter, value bool) error {
+			if value {
+				return w.WriteByte(1)
+			}
+			return w.WriteByte(0)
+		},
+		func(r *animxReader) (bool, error) {
+			b, err := r.readByte()
+			return b != 0, err
+		})
+	registerFixed[int32](ValueTypeInt)
+	registerValueType(ValueTypeInt, true,
+		func(w animxWriter, value int) error {
+			if value < math.MinInt32 || value > math.MaxInt32 {
+				return fmt.Errorf("%d does not fit in an int track", value)
+			}
+			return binary.Write(w, binary.LittleEndian, int32(value))
+		},
+		func(r *animxReader) (int, error) {
+			value, err := r.readInt32()
+			return int(value), err
+		})
+	registerFixed[int64](ValueTypeLong)
+	registerFixed[float32](ValueTypeFloat)
+	registerFixed[Float2](ValueTypeFloat2)
+	registerFixed[Float3](ValueTypeFloat3)
+	registerFixed[Float4](ValueTypeFloat4)
+	registerFixed[FloatQ](ValueTypeFloatQ)
+	registerFixed[float64](ValueTypeDouble)
+	registerFixed[DoubleQ](ValueTypeDoubleQ)
+	registerValueType(ValueTypeString, false,
+		func(w animxWriter, value string) error {
+			w.Write(encodeAnimString(value, true))
+			return nil
+		},
+		func(r *animxReader) (string, error) {
+			return r.readString(true)
+		})
+	registerValueType(ValueTypeUri, false,
+		func(w animxWriter, value Uri) error {
+			if value == "" {
+				return w.WriteByte(0)
+			}
+			w.Write(encodeAnimString(string(value), true))
+			return nil
+		},
+		func(r *animxReader) (Uri, error) {
+			value, err := r.readString(true)
+			return Uri(value), err
+		})
+	registerFixed[ColorX](ValueTypeColorX)
+}
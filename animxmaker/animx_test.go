@@ -0,0 +1,185 @@
+package animxmaker
+
+import (
+	"bytes"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRead7BitEncodedInt(t *testing.T) {
+	for _, n := range []int{0, 1, 127, 128, 255, 16383, 16384, 1 << 21, math.MaxInt32} {
+		r := &animxReader{data: write7BitEncodedInt(n)}
+		got, err := r.read7BitEncodedInt()
+		if err != nil {
+			t.Fatalf("read7BitEncodedInt(%d): %v", n, err)
+		}
+		if got != n {
+			t.Errorf("read7BitEncodedInt = %d, want %d", got, n)
+		}
+		if r.pos != len(r.data) {
+			t.Errorf("read7BitEncodedInt(%d) left %d bytes", n, len(r.data)-r.pos)
+		}
+	}
+}
+
+// roundTrip encodes an animation, decodes it and checks that the decoded
+// tracks match want and encode to the same bytes again
+func roundTrip(t *testing.T, tracks []AnimationTrackWrapper, want []AnimationTrackWrapper) {
+	t.Helper()
+	animation := Animation{Tracks: tracks}
+	encoded, err := animation.EncodeAnimation("response")
+	if err != nil {
+		t.Fatalf("EncodeAnimation: %v", err)
+	}
+	decoded, name, err := DecodeAnimation(encoded)
+	if err != nil {
+		t.Fatalf("DecodeAnimation: %v", err)
+	}
+	if name != "response" {
+		t.Errorf("name = %q, want %q", name, "response")
+	}
+	if !reflect.DeepEqual(decoded.Tracks, want) {
+		t.Errorf("tracks = %#v, want %#v", decoded.Tracks, want)
+	}
+	reencoded, err := decoded.EncodeAnimation(name)
+	if err != nil {
+		t.Fatalf("EncodeAnimation of decoded animation: %v", err)
+	}
+	if !bytes.Equal(reencoded, encoded) {
+		t.Errorf("re-encoded animation differs from the original")
+	}
+}
+
+func TestRoundTripValueTypes(t *testing.T) {
+	names := ListTrack([]string{"", "a", "ünïcødé", strings.Repeat("x", 300)}, "results", "name")
+	ints := ListTrack([]int32{0, 1, -1, math.MaxInt32, math.MinInt32}, "results", "id")
+	floats := ListTrack([]float32{0, 1.5, -2.25, math.MaxFloat32, float32(math.Inf(1))}, "results", "usedMB")
+
+	tracks := []AnimationTrackWrapper{&names, &ints, &floats}
+	roundTrip(t, tracks, tracks)
+}
+
+func TestRoundTripExtendedValueTypes(t *testing.T) {
+	bools := ListTrack([]bool{true, false}, "results", "shared")
+	longs := ListTrack([]int64{0, -1, math.MaxInt64, math.MinInt64}, "results", "bytes")
+	doubles := ListTrack([]float64{0, -0.5, math.MaxFloat64}, "results", "ratio")
+	float2s := ListTrack([]Float2{{1, 2}, {-3, 4.5}}, "results", "size")
+	float3s := ListTrack([]Float3{{1, 2, 3}}, "results", "position")
+	float4s := ListTrack([]Float4{{1, 2, 3, 4}}, "results", "bounds")
+	floatQs := ListTrack([]FloatQ{{0, 0, 0, 1}}, "results", "rotation")
+	doubleQs := ListTrack([]DoubleQ{{0, 0.5, 0, 1}}, "results", "rotation")
+	colors := ListTrack([]ColorX{{1, 0.5, 0, 1, ColorProfileSRGB}, {0, 0, 0, 0, ColorProfileLinear}}, "results", "color")
+	uris := ListTrack([]Uri{"https://example.com/assets/abc", ""}, "results", "url")
+
+	tracks := []AnimationTrackWrapper{&bools, &longs, &doubles, &float2s, &float3s, &float4s, &floatQs, &doubleQs, &colors, &uris}
+	roundTrip(t, tracks, tracks)
+}
+
+func TestRoundTripGoInt(t *testing.T) {
+	ids := ListTrack([]int{1, -2, math.MaxInt32}, "results", "id")
+	want := ListTrack([]int32{1, -2, math.MaxInt32}, "results", "id")
+	roundTrip(t, []AnimationTrackWrapper{&ids}, []AnimationTrackWrapper{&want})
+
+	tooLarge := ListTrack([]int{math.MaxInt32 + 1}, "results", "id")
+	if _, err := tooLarge.EncodeTrack(); err == nil {
+		t.Error("EncodeTrack accepted an int that does not fit in an int track")
+	}
+}
+
+func TestUnsupportedValueType(t *testing.T) {
+	track := ListTrack([]uint8{1}, "results", "id")
+	if _, err := track.EncodeTrack(); err == nil {
+		t.Error("EncodeTrack accepted an unregistered value type")
+	}
+}
+
+func TestRoundTripTrackTypes(t *testing.T) {
+	raw := RawListTrack([]float32{0, 0.25, 1}, 0.5, "slider", "value")
+	linear := LinearTrack([]KeyFrame[Float3]{{0, Float3{0, 0, 0}}, {2, Float3{1, 2, 3}}}, "slot", "position")
+	curve := CurveTrack([]CurveKeyFrame[float64]{
+		{Position: 0, Value: 0, Interpolation: InterpolationTangent, LeftTangent: -1, RightTangent: 1},
+		{Position: 1, Value: 1, Interpolation: InterpolationHold},
+	}, "slider", "value")
+	bezier := BezierTrack([]BezierKeyFrame[FloatQ]{
+		{Position: 0, Value: FloatQ{0, 0, 0, 1}, LeftTangent: FloatQ{0, 0, 0, 1}, RightTangent: FloatQ{0, 0.1, 0, 1}},
+		{Position: 3, Value: FloatQ{0, 1, 0, 0}, LeftTangent: FloatQ{0, 0.9, 0, 0.1}, RightTangent: FloatQ{0, 1, 0, 0}},
+	}, "slot", "rotation")
+	discrete := ListTrack([]string{"a", "b"}, "results", "name")
+
+	tracks := []AnimationTrackWrapper{&raw, &linear, &curve, &bezier, &discrete}
+	roundTrip(t, tracks, tracks)
+
+	animation := Animation{Tracks: tracks}
+	encoded, err := animation.EncodeAnimation("response")
+	if err != nil {
+		t.Fatalf("EncodeAnimation: %v", err)
+	}
+	r := &animxReader{data: encoded}
+	r.readString(false)
+	r.readInt32()
+	r.read7BitEncodedInt()
+	if duration, _ := r.readFloat32(); duration != 3 {
+		t.Errorf("duration = %v, want 3", duration)
+	}
+}
+
+func TestInterpolatedTracksRejectDiscreteTypes(t *testing.T) {
+	curve := LinearTrack([]KeyFrame[string]{{0, "a"}, {1, "b"}}, "text", "content")
+	if _, err := curve.EncodeTrack(); err == nil {
+		t.Error("curve track accepted string values")
+	}
+	bezier := BezierTrack([]BezierKeyFrame[bool]{{Position: 0, Value: true}}, "slot", "active")
+	if _, err := bezier.EncodeTrack(); err == nil {
+		t.Error("bezier track accepted bool values")
+	}
+}
+
+func TestRoundTripEmptyTrack(t *testing.T) {
+	empty := ListTrack([]int32{}, "results", "id")
+	placeholder := AnimationTrack[string]{
+		Node:      "results",
+		Property:  "id",
+		Keyframes: []KeyFrame[string]{{Position: 0, Value: "No results"}},
+	}
+	roundTrip(t, []AnimationTrackWrapper{&empty}, []AnimationTrackWrapper{&placeholder})
+}
+
+func TestRoundTripNoTracks(t *testing.T) {
+	animation := Animation{}
+	encoded, err := animation.EncodeAnimation("response")
+	if err != nil {
+		t.Fatalf("EncodeAnimation: %v", err)
+	}
+	decoded, _, err := DecodeAnimation(encoded)
+	if err != nil {
+		t.Fatalf("DecodeAnimation: %v", err)
+	}
+	if len(decoded.Tracks) != 0 {
+		t.Errorf("decoded %d tracks, want none", len(decoded.Tracks))
+	}
+}
+
+func TestDecodeAnimationErrors(t *testing.T) {
+	ids := ListTrack([]int32{1, 2, 3}, "results", "id")
+	animation := Animation{Tracks: []AnimationTrackWrapper{&ids}}
+	encoded, err := animation.EncodeAnimation("response")
+	if err != nil {
+		t.Fatalf("EncodeAnimation: %v", err)
+	}
+
+	for i := 0; i < len(encoded); i++ {
+		if _, _, err := DecodeAnimation(encoded[:i]); err == nil {
+			t.Errorf("DecodeAnimation accepted %d of %d bytes", i, len(encoded))
+		}
+	}
+	if _, _, err := DecodeAnimation(append(encoded, 0)); err == nil {
+		t.Error("DecodeAnimation accepted trailing bytes")
+	}
+	corrupt := append([]byte{}, encoded...)
+	corrupt[1] = 'B'
+	if _, _, err := DecodeAnimation(corrupt); err == nil {
+		t.Error("DecodeAnimation accepted a wrong magic word")
+	}
+}
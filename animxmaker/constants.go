@@ -2,17 +2,41 @@ package animxmaker
 
 // Track types
 const (
-	TrackTypeStandard = 1
+	TrackTypeRaw      = 0
+	TrackTypeDiscrete = 1
+	TrackTypeCurve    = 2
+	TrackTypeBezier   = 3
+
+	// TrackTypeStandard is the discrete track ListTrack builds
+	TrackTypeStandard = TrackTypeDiscrete
 )
 
-// Value types
+// Curve keyframe interpolations
 const (
-	ValueTypeInt    = 10
-	ValueTypeFloat  = 21
-	ValueTypeString = 39
+	InterpolationHold        = 0
+	InterpolationLinear      = 1
+	InterpolationTangent     = 2
+	InterpolationCubicBezier = 3
+)
+
+// Value types, numbered as in Resonite's list of animatable types
+const (
+	ValueTypeBool    = 0
+	ValueTypeInt     = 10
+	ValueTypeLong    = 14
+	ValueTypeFloat   = 21
+	ValueTypeFloat2  = 22
+	ValueTypeFloat3  = 23
+	ValueTypeFloat4  = 24
+	ValueTypeFloatQ  = 25
+	ValueTypeDouble  = 29
+	ValueTypeDoubleQ = 33
+	ValueTypeString  = 39
+	ValueTypeUri     = 40
+	ValueTypeColorX  = 41
 )
 
 // Encoding types
 const (
 	EncodingTypeBinary = 0
-)
\ No newline at end of file
+)
@@ -0,0 +1,148 @@
+package animxmaker
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+type testOwner struct {
+	ID   int32  `animx:"id"`
+	Name string `animx:"name"`
+}
+
+type testItem struct {
+	ID       int    `animx:"id"`
+	Name     string `animx:"name"`
+	Size     float32
+	Owner    testOwner `animx:"owner"`
+	Internal string    `animx:"-"`
+	hidden   string
+}
+
+func encodeTracks(t *testing.T, tracks []AnimationTrackWrapper) []byte {
+	t.Helper()
+	animation := Animation{Tracks: tracks}
+	encoded, err := animation.EncodeAnimation("response")
+	if err != nil {
+		t.Fatalf("EncodeAnimation: %v", err)
+	}
+	return encoded
+}
+
+func TestMarshalMatchesListTrack(t *testing.T) {
+	items := []testItem{
+		{ID: 1, Name: "a", Size: 1.5, Owner: testOwner{7, "alice"}},
+		{ID: 2, Name: "b", Size: 2.5, Owner: testOwner{8, "bob"}},
+	}
+	tracks, err := Marshal(items, "results")
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+
+	ids := ListTrack([]int{1, 2}, "results", "id")
+	names := ListTrack([]string{"a", "b"}, "results", "name")
+	sizes := ListTrack([]float32{1.5, 2.5}, "results", "Size")
+	ownerIds := ListTrack([]int32{7, 8}, "results/owner", "id")
+	ownerNames := ListTrack([]string{"alice", "bob"}, "results/owner", "name")
+	want := encodeTracks(t, []AnimationTrackWrapper{&ids, &names, &sizes, &ownerIds, &ownerNames})
+
+	if got := encodeTracks(t, tracks); !bytes.Equal(got, want) {
+		t.Error("Marshal encodes differently from the equivalent ListTracks")
+	}
+}
+
+func TestMarshalEmptyMatchesListTrack(t *testing.T) {
+	tracks, err := Marshal([]testOwner{}, "results")
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	ids := ListTrack([]int32{}, "results", "id")
+	names := ListTrack([]string{}, "results", "name")
+	want := encodeTracks(t, []AnimationTrackWrapper{&ids, &names})
+	if got := encodeTracks(t, tracks); !bytes.Equal(got, want) {
+		t.Error("Marshal of an empty slice encodes differently from empty ListTracks")
+	}
+}
+
+func TestUnmarshalRoundTrip(t *testing.T) {
+	items := []*testItem{
+		{ID: 1, Name: "a", Size: 1.5, Owner: testOwner{7, "alice"}},
+		{ID: 2, Name: "No results", Size: 2.5, Owner: testOwner{8, "bob"}},
+	}
+	tracks, err := Marshal(items, "results")
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	decoded, _, err := DecodeAnimation(encodeTracks(t, tracks))
+	if err != nil {
+		t.Fatalf("DecodeAnimation: %v", err)
+	}
+	var got []*testItem
+	if err := Unmarshal(decoded, "results", &got); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	if !reflect.DeepEqual(got, items) {
+		t.Errorf("Unmarshal = %+v, want %+v", got, items)
+	}
+}
+
+func TestUnmarshalEmpty(t *testing.T) {
+	tracks, err := Marshal([]testItem{}, "results")
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	decoded, _, err := DecodeAnimation(encodeTracks(t, tracks))
+	if err != nil {
+		t.Fatalf("DecodeAnimation: %v", err)
+	}
+	got := []testItem{{ID: 5}}
+	if err := Unmarshal(decoded, "results", &got); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	if len(got) != 0 {
+		t.Errorf("Unmarshal of empty tracks gave %d elements", len(got))
+	}
+}
+
+func TestMarshalErrors(t *testing.T) {
+	type unsupported struct {
+		Tags []string `animx:"tags"`
+	}
+	if _, err := Marshal([]unsupported{{}}, "results"); err == nil {
+		t.Error("Marshal accepted a slice field")
+	}
+	if _, err := Marshal(testOwner{}, "results"); err == nil {
+		t.Error("Marshal accepted a struct instead of a slice")
+	}
+	if _, err := Marshal([]*testOwner{nil}, "results"); err == nil {
+		t.Error("Marshal accepted a nil element")
+	}
+	var owners []testOwner
+	if err := Unmarshal(&Animation{}, "results", owners); err == nil {
+		t.Error("Unmarshal accepted a slice instead of a pointer to one")
+	}
+}
+
+func TestUnmarshalUsesCountTrack(t *testing.T) {
+	for _, owners := range [][]testOwner{{}, {{ID: 1, Name: "No results"}}} {
+		tracks, err := Marshal(owners, "results")
+		if err != nil {
+			t.Fatalf("Marshal: %v", err)
+		}
+		count := CountTrack(len(owners), "results")
+		tracks = append(tracks, &count)
+		tracks = append(tracks, StatusTracks(200, "ok", "")...)
+		decoded, _, err := DecodeAnimation(encodeTracks(t, tracks))
+		if err != nil {
+			t.Fatalf("DecodeAnimation: %v", err)
+		}
+		var got []testOwner
+		if err := Unmarshal(decoded, "results", &got); err != nil {
+			t.Fatalf("Unmarshal: %v", err)
+		}
+		if len(got) != len(owners) || (len(owners) > 0 && got[0] != owners[0]) {
+			t.Errorf("Unmarshal = %+v, want %+v", got, owners)
+		}
+	}
+}
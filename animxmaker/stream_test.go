@@ -0,0 +1,89 @@
+package animxmaker
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+// goldenAnimation is testTracks as encoded by the buffering encoder the
+// streaming one replaced
+const goldenAnimation = "05416e696d5801000000050000804008726573706f6e736500010a07726573756c74730269640300000000010000000000803f02000000000000402c010000012707726573756c7473046e616d6502000000000101610000803f0102c3bc012707726573756c747306757365644d420100000000010a4e6f20726573756c7473001d06736c696465720576616c7565020000003f0000000000000000000000000000f03f021604736c6f740473697a65020000000001000000000000803f000000000000000000000000000000000000804001000000400000404000000000000000000000000000000000"
+
+func testTracks() []AnimationTrackWrapper {
+	ids := ListTrack([]int32{1, 2, 300}, "results", "id")
+	names := ListTrack([]string{"a", "ü"}, "results", "name")
+	empty := ListTrack([]float32{}, "results", "usedMB")
+	raw := RawListTrack([]float64{0, 1}, 0.5, "slider", "value")
+	curve := LinearTrack([]KeyFrame[Float2]{{0, Float2{0, 1}}, {4, Float2{2, 3}}}, "slot", "size")
+	return []AnimationTrackWrapper{&ids, &names, &empty, &raw, &curve}
+}
+
+func TestEncoderMatchesGolden(t *testing.T) {
+	want, err := hex.DecodeString(goldenAnimation)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	animation := Animation{Tracks: testTracks()}
+	encoded, err := animation.EncodeAnimation("response")
+	if err != nil {
+		t.Fatalf("EncodeAnimation: %v", err)
+	}
+	if !bytes.Equal(encoded, want) {
+		t.Errorf("EncodeAnimation = %x, want %x", encoded, want)
+	}
+
+	var streamed bytes.Buffer
+	if err := NewEncoder(&streamed).Encode(&animation, "response"); err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+	if !bytes.Equal(streamed.Bytes(), want) {
+		t.Errorf("Encode = %x, want %x", streamed.Bytes(), want)
+	}
+}
+
+func TestEncoderDeclaredHeader(t *testing.T) {
+	want, err := hex.DecodeString(goldenAnimation)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tracks := testTracks()
+
+	var streamed bytes.Buffer
+	encoder := NewEncoder(&streamed)
+	if err := encoder.WriteHeader("response", len(tracks), 4); err != nil {
+		t.Fatalf("WriteHeader: %v", err)
+	}
+	for _, track := range tracks {
+		if err := encoder.WriteTrack(track); err != nil {
+			t.Fatalf("WriteTrack: %v", err)
+		}
+	}
+	if err := encoder.WriteTrack(tracks[0]); err == nil {
+		t.Error("WriteTrack accepted more tracks than declared")
+	}
+	if err := encoder.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if !bytes.Equal(streamed.Bytes(), want) {
+		t.Errorf("streamed animation = %x, want %x", streamed.Bytes(), want)
+	}
+}
+
+func TestEncoderMissingTracks(t *testing.T) {
+	var streamed bytes.Buffer
+	encoder := NewEncoder(&streamed)
+	if err := encoder.WriteTrack(testTracks()[0]); err == nil {
+		t.Error("WriteTrack accepted a track before the header")
+	}
+	if err := encoder.WriteHeader("response", 2, 0); err != nil {
+		t.Fatalf("WriteHeader: %v", err)
+	}
+	if err := encoder.WriteTrack(testTracks()[0]); err != nil {
+		t.Fatalf("WriteTrack: %v", err)
+	}
+	if err := encoder.Close(); err == nil {
+		t.Error("Close accepted fewer tracks than declared")
+	}
+}
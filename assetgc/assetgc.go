@@ -0,0 +1,282 @@
+package assetgc
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"resonite-file-provider/assetinfo"
+	"resonite-file-provider/database"
+	"resonite-file-provider/storage"
+	"strings"
+	"time"
+)
+
+// Options control a garbage collection run
+type Options struct {
+	// DryRun only reports what would be deleted
+	DryRun bool
+	// Grace is how old an unreferenced file must be before it is deleted, so
+	// uploads that are still in progress are left alone
+	Grace time.Duration
+	// Verify re-hashes every stored asset to detect corruption
+	Verify bool
+}
+
+// Report describes the outcome of a garbage collection run
+type Report struct {
+	Files int
+	// DeletedFiles are stored files no row refers to
+	DeletedFiles []string
+	// FailedFiles are unreferenced files that couldn't be deleted, each with
+	// the error
+	FailedFiles []string
+	// DeletedRows are Assets rows no item uses anymore
+	DeletedRows []string
+	// FailedRows are unused Assets rows that couldn't be deleted, such as
+	// rows asset_tags still refers to, each with the error
+	FailedRows []string
+	// FreedBytes is the size of DeletedFiles
+	FreedBytes int64
+	// Pending counts unreferenced files still inside the grace period
+	Pending int
+	// Missing are assets in use whose file is gone
+	Missing []string
+	// Corrupt are assets whose contents no longer match their hash
+	Corrupt []string
+	// SizesRecorded counts rows whose missing size was filled in
+	SizesRecorded int
+	// TypesRecorded counts rows whose missing content type was filled in
+	TypesRecorded int
+}
+
+func (r *Report) String() string {
+	return fmt.Sprintf("%d files scanned, %d unreferenced files (%d bytes) and %d unused rows removed, %d files and %d rows failed to delete, %d files within grace period, %d missing, %d corrupt",
+		r.Files, len(r.DeletedFiles), r.FreedBytes, len(r.DeletedRows), len(r.FailedFiles), len(r.FailedRows), r.Pending, len(r.Missing), len(r.Corrupt))
+}
+
+type assetRow struct {
+	id    int
+	size  int64
+	typed bool
+	usage int
+}
+
+// loadAssetRows returns every Assets row by hash with its hash-usage count
+func loadAssetRows() (map[string]assetRow, error) {
+	rows, err := database.Db.Query("SELECT a.id, a.hash, a.size, a.content_type IS NOT NULL, COUNT(hu.id) FROM Assets a LEFT JOIN `hash-usage` hu ON hu.asset_id = a.id GROUP BY a.id, a.hash, a.size, a.content_type")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	assets := make(map[string]assetRow)
+	for rows.Next() {
+		var hash string
+		var row assetRow
+		if err := rows.Scan(&row.id, &hash, &row.size, &row.typed, &row.usage); err != nil {
+			return nil, err
+		}
+		assets[hash] = row
+	}
+	return assets, rows.Err()
+}
+
+// loadRecordHashes returns the record hash of every item
+func loadRecordHashes() (map[string]bool, error) {
+	rows, err := database.Db.Query("SELECT DISTINCT url FROM Items")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	records := make(map[string]bool)
+	for rows.Next() {
+		var hash string
+		if err := rows.Scan(&hash); err != nil {
+			return nil, err
+		}
+		records[hash] = true
+	}
+	return records, rows.Err()
+}
+
+// hashMatches re-hashes a stored asset and compares it with its name
+func hashMatches(name string) (bool, error) {
+	reader, err := storage.Assets.Get(name, 0, -1)
+	if err != nil {
+		return false, err
+	}
+	defer reader.Close()
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, reader); err != nil {
+		return false, err
+	}
+	return hex.EncodeToString(hasher.Sum(nil)) == name, nil
+}
+
+// detectStored sniffs the content type and kind of a stored file
+func detectStored(name string) (string, string, error) {
+	reader, err := storage.Assets.Get(name, 0, assetinfo.SniffLen)
+	if err != nil {
+		return "", "", err
+	}
+	defer reader.Close()
+	head, err := io.ReadAll(reader)
+	if err != nil {
+		return "", "", err
+	}
+	contentType, kind := assetinfo.Detect(head)
+	return contentType, kind, nil
+}
+
+// Run reconciles stored files with the Assets and hash-usage tables. Files
+// nothing refers to are deleted once they are older than the grace period,
+// Assets rows no item uses are dropped, and rows whose file is missing are
+// reported.
+func Run(opts Options) (*Report, error) {
+	report := &Report{}
+	files := make(map[string]storage.ObjectInfo)
+	err := storage.Assets.List(func(info storage.ObjectInfo) error {
+		files[info.Name] = info
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list stored files: %w", err)
+	}
+	report.Files = len(files)
+
+	assets, err := loadAssetRows()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load assets: %w", err)
+	}
+	records, err := loadRecordHashes()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load items: %w", err)
+	}
+
+	cutoff := time.Now().Add(-opts.Grace)
+	for name, info := range files {
+		hash := strings.TrimSuffix(name, ".brson")
+		referenced := assets[hash].usage > 0
+		if strings.HasSuffix(name, ".brson") {
+			referenced = records[hash]
+		}
+		if referenced {
+			continue
+		}
+		if info.ModTime.After(cutoff) {
+			report.Pending++
+			continue
+		}
+		// Like rows below, a file that can't be deleted is retried next run
+		if !opts.DryRun {
+			if err := storage.Assets.Delete(name); err != nil {
+				report.FailedFiles = append(report.FailedFiles, name+": "+err.Error())
+				continue
+			}
+		}
+		report.DeletedFiles = append(report.DeletedFiles, name)
+		report.FreedBytes += info.Size
+	}
+
+	for hash, row := range assets {
+		_, hasFile := files[hash]
+		_, hasRecord := files[hash+".brson"]
+		if row.usage > 0 {
+			if !hasFile && !hasRecord {
+				report.Missing = append(report.Missing, hash)
+				continue
+			}
+			// Rows created before sizes were recorded get them from storage
+			if row.size == 0 && !opts.DryRun {
+				size := files[hash].Size
+				if !hasFile {
+					size = files[hash+".brson"].Size
+				}
+				if _, err := database.Db.Exec("UPDATE Assets SET size = ? WHERE id = ?", size, row.id); err != nil {
+					return report, fmt.Errorf("failed to record size of %s: %w", hash, err)
+				}
+				report.SizesRecorded++
+			}
+			// Likewise for content types, sniffed from the start of the file
+			if !row.typed && !opts.DryRun {
+				name := hash
+				if !hasFile {
+					name = hash + ".brson"
+				}
+				contentType, kind, err := detectStored(name)
+				if err != nil {
+					return report, fmt.Errorf("failed to read %s: %w", name, err)
+				}
+				if _, err := database.Db.Exec("UPDATE Assets SET content_type = ?, kind = ? WHERE id = ?", contentType, kind, row.id); err != nil {
+					return report, fmt.Errorf("failed to record type of %s: %w", hash, err)
+				}
+				report.TypesRecorded++
+			}
+			continue
+		}
+		// A row without usage may belong to an upload that is still linking
+		// its assets, so it is only dropped along with an expired file.
+		// Records are stored as <hash>.brson.
+		if hasFile && files[hash].ModTime.After(cutoff) {
+			continue
+		}
+		if hasRecord && files[hash+".brson"].ModTime.After(cutoff) {
+			continue
+		}
+		// A row that can't be deleted doesn't stop the run, the next one
+		// tries again
+		if !opts.DryRun {
+			if _, err := database.Db.Exec("DELETE FROM Assets WHERE id = ?", row.id); err != nil {
+				report.FailedRows = append(report.FailedRows, hash+": "+err.Error())
+				continue
+			}
+		}
+		report.DeletedRows = append(report.DeletedRows, hash)
+	}
+
+	if opts.Verify {
+		for name := range files {
+			// Records may have been re-encoded by older versions, so only
+			// plain assets are expected to match their hash
+			if strings.HasSuffix(name, ".brson") || assets[name].usage == 0 {
+				continue
+			}
+			matches, err := hashMatches(name)
+			if err != nil {
+				return report, fmt.Errorf("failed to verify %s: %w", name, err)
+			}
+			if !matches {
+				report.Corrupt = append(report.Corrupt, name)
+			}
+		}
+	}
+	return report, nil
+}
+
+// Schedule runs the garbage collector every interval, logging each report
+func Schedule(interval time.Duration, opts Options) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		report, err := Run(opts)
+		if err != nil {
+			fmt.Println("[GC] Run failed:", err.Error())
+			continue
+		}
+		fmt.Println("[GC]", report.String())
+		for _, failure := range report.FailedFiles {
+			fmt.Println("[GC] Failed to delete file", failure)
+		}
+		for _, failure := range report.FailedRows {
+			fmt.Println("[GC] Failed to delete asset row", failure)
+		}
+		for _, hash := range report.Missing {
+			fmt.Println("[GC] Missing file for asset", hash)
+		}
+		for _, hash := range report.Corrupt {
+			fmt.Println("[GC] Corrupt asset", hash)
+		}
+	}
+}
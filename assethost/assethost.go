@@ -1,21 +1,27 @@
 package assethost
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"net/http"
+	"net/url"
 	"resonite-file-provider/authentication"
+	"resonite-file-provider/brson"
 	"resonite-file-provider/config"
 	"resonite-file-provider/database"
+	"resonite-file-provider/storage"
 	"strings"
 )
 
 func isOwnedBy(owner int, url string) bool {
 	var exists bool
 	url = strings.TrimSuffix(url, ".brson")
-	
+
 	// Updated query to use the new schema structure
 	database.Db.QueryRow(`
 		SELECT EXISTS (
-			SELECT 1 
+			SELECT 1
 			FROM Users u
 			INNER JOIN users_inventories ui ON u.id = ui.user_id
 			INNER JOIN Inventories i ON ui.inventory_id = i.id
@@ -24,14 +30,119 @@ func isOwnedBy(owner int, url string) bool {
 			WHERE u.id = ? AND it.url = ?
 		)
 	`, owner, url).Scan(&exists)
-	
+
 	return exists
 }
 
+// storedContentType returns the content type recorded for an asset when it
+// was uploaded, or "" if it is unknown
+func storedContentType(hash string) string {
+	var contentType string
+	database.Db.QueryRow("SELECT COALESCE(content_type, '') FROM Assets WHERE hash = ?", hash).Scan(&contentType)
+	return contentType
+}
+
+// firstHeaderValue returns the first entry of a comma separated header such
+// as those appended to by each proxy in a chain
+func firstHeaderValue(r *http.Request, name string) string {
+	value, _, _ := strings.Cut(r.Header.Get(name), ",")
+	return strings.TrimSpace(value)
+}
+
+// PublicBaseURL returns the address clients reach the server at, taken from
+// the PublicBaseURL setting or else from the (possibly proxied) request
+func PublicBaseURL(r *http.Request) string {
+	if base := config.GetConfig().Server.PublicBaseURL; base != "" {
+		return strings.TrimSuffix(base, "/")
+	}
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	if proto := firstHeaderValue(r, "X-Forwarded-Proto"); proto != "" {
+		scheme = proto
+	}
+	host := r.Host
+	if forwardedHost := firstHeaderValue(r, "X-Forwarded-Host"); forwardedHost != "" {
+		host = forwardedHost
+	}
+	return (&url.URL{Scheme: scheme, Host: host}).String()
+}
+
+// serveRecord sends a stored record with its packdb:/// references pointing
+// at this server's asset URLs as seen by the requesting client
+func serveRecord(w http.ResponseWriter, r *http.Request) {
+	info, err := storage.Assets.Stat(r.URL.Path)
+	if err != nil {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+	data, err := storage.ReadAll(storage.Assets, r.URL.Path)
+	if err != nil {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+	doc, err := brson.Read(data)
+	if err != nil {
+		http.Error(w, "Stored record is invalid", http.StatusInternalServerError)
+		return
+	}
+	assetsUrl, err := url.JoinPath(PublicBaseURL(r), "assets")
+	if err != nil {
+		http.Error(w, "Public base URL is invalid", http.StatusInternalServerError)
+		return
+	}
+	brson.RewriteAssetUris(doc, brson.AssetScheme, assetsUrl+"/")
+	record, err := brson.Write(doc)
+	if err != nil {
+		http.Error(w, "Failed to encode record", http.StatusInternalServerError)
+		return
+	}
+	// The served bytes depend on the base URL, so the tag is taken from them
+	// rather than from the stored record. Records sit behind auth, so caches
+	// have to revalidate them on every use.
+	sum := sha256.Sum256(record)
+	w.Header().Set("ETag", `"`+hex.EncodeToString(sum[:])+`"`)
+	w.Header().Set("Cache-Control", "private, no-cache")
+	w.Header().Set("Content-Type", "application/octet-stream")
+	http.ServeContent(w, r, r.URL.Path, info.ModTime, bytes.NewReader(record))
+}
+
+// serveAsset sends a stored asset. Assets are named by the hash of their
+// contents and never change, so they may be cached forever and the hash is
+// their ETag. http.ServeContent answers conditional, HEAD and range requests
+// through ranged reads, which works on every storage backend.
+func serveAsset(w http.ResponseWriter, r *http.Request) {
+	info, err := storage.Assets.Stat(r.URL.Path)
+	if err != nil {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+	// Without a stored type http.ServeContent sniffs one from the contents
+	if contentType := storedContentType(r.URL.Path); contentType != "" {
+		w.Header().Set("Content-Type", contentType)
+	}
+	w.Header().Set("ETag", `"`+r.URL.Path+`"`)
+	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	content := storage.NewReadSeeker(storage.Assets, r.URL.Path, info.Size)
+	defer content.Close()
+	http.ServeContent(w, r, r.URL.Path, info.ModTime, content)
+}
+
 func handleRequest(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		r.URL.Path = strings.TrimPrefix(r.URL.Path, "/assets/")
+		// Assets are addressed by bare hash, never by path
+		if r.URL.Path == "" || strings.Contains(r.URL.Path, "/") || strings.HasPrefix(r.URL.Path, ".") {
+			http.Error(w, "Not found", http.StatusNotFound)
+			return
+		}
 		if !strings.HasSuffix(r.URL.Path, ".brson") {
+			// Records refer to assets with extensions such as .meshx, the
+			// stored object is named by the bare hash
+			if hash, _, ok := strings.Cut(r.URL.Path, "."); ok {
+				r.URL.Path = hash
+			}
 			next.ServeHTTP(w, r)
 			return
 		}
@@ -46,10 +157,10 @@ func handleRequest(next http.Handler) http.Handler {
 			http.Error(w, "Forbidden", http.StatusForbidden)
 			return
 		}
-		next.ServeHTTP(w, r)
+		serveRecord(w, r)
 	})
 }
 
 func AddAssetListeners() {
-	http.Handle("/assets/", handleRequest(http.FileServer(http.Dir(config.GetConfig().Server.AssetsPath))))
-}
\ No newline at end of file
+	http.Handle("/assets/", handleRequest(http.HandlerFunc(serveAsset)))
+}
@@ -0,0 +1,79 @@
+package assethost
+
+import (
+	"database/sql"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"resonite-file-provider/brson"
+	"resonite-file-provider/database"
+	"resonite-file-provider/storage"
+	"strings"
+	"testing"
+)
+
+const fixtureMesh = "3333333333333333333333333333333333333333333333333333333333333333"
+
+func TestServeRewrittenAssetUrl(t *testing.T) {
+	local, err := storage.NewLocal(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	storage.Assets = storage.NewSharded(local)
+	if err := storage.Assets.Put(fixtureMesh, strings.NewReader("mesh data"), 9); err != nil {
+		t.Fatal(err)
+	}
+	// Nothing listens there, so no content type is found and it is sniffed
+	db, err := sql.Open("mysql", "test@tcp(127.0.0.1:1)/test?timeout=100ms")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	database.Db = db
+
+	mux := http.NewServeMux()
+	mux.Handle("/assets/", handleRequest(http.HandlerFunc(serveAsset)))
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	data, err := os.ReadFile("../brson/testdata/record.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc, err := brson.FromJSON(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	brson.RewriteAssetUris(doc, brson.AssetScheme, server.URL+"/assets/")
+	value, err := brson.Select(doc, "Object.Children.0.Components.Data.0.Data.URL.Data")
+	if err != nil {
+		t.Fatal(err)
+	}
+	uri := strings.TrimPrefix(value.(string), "@")
+	if want := server.URL + "/assets/" + fixtureMesh + ".meshx"; uri != want {
+		t.Fatalf("rewritten URI = %q, want %q", uri, want)
+	}
+
+	resp, err := http.Get(uri)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK || string(body) != "mesh data" {
+		t.Errorf("GET %s = %s %q, want 200 %q", uri, resp.Status, body, "mesh data")
+	}
+	if etag := resp.Header.Get("ETag"); etag != `"`+fixtureMesh+`"` {
+		t.Errorf("ETag = %s, want the bare hash", etag)
+	}
+
+	resp, err = http.Get(server.URL + "/assets/" + strings.Repeat("5", 64) + ".meshx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("GET of a missing asset = %s, want 404", resp.Status)
+	}
+}
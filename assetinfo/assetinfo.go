@@ -0,0 +1,64 @@
+package assetinfo
+
+import (
+	"bytes"
+	"net/http"
+	"resonite-file-provider/brson"
+	"strings"
+)
+
+// Kinds of asset recorded in the Assets table
+const (
+	KindTexture = "texture"
+	KindMesh    = "mesh"
+	KindAudio   = "audio"
+	KindRecord  = "record"
+	KindOther   = "other"
+)
+
+// SniffLen is how many leading bytes Detect looks at
+const SniffLen = 512
+
+type signature struct {
+	prefix      []byte
+	contentType string
+	kind        string
+}
+
+// signatures covers formats Resonite uses that http.DetectContentType does
+// not know. Resonite's own formats start with a length prefixed name.
+var signatures = []signature{
+	{brson.Header, "application/octet-stream", KindRecord},
+	{[]byte("\x05MeshX"), "application/x-meshx", KindMesh},
+	{[]byte("\x05AnimX"), "application/x-animx", KindOther},
+	{[]byte("glTF"), "model/gltf-binary", KindMesh},
+	{[]byte("Kaydara FBX Binary"), "application/octet-stream", KindMesh},
+	{[]byte("DDS "), "image/vnd-ms.dds", KindTexture},
+	{[]byte("\xabKTX 11\xbb"), "image/ktx", KindTexture},
+	{[]byte("\xabKTX 20\xbb"), "image/ktx2", KindTexture},
+	{[]byte("\x76\x2f\x31\x01"), "image/x-exr", KindTexture},
+	{[]byte("#?RADIANCE"), "image/vnd.radiance", KindTexture},
+	{[]byte("fLaC"), "audio/flac", KindAudio},
+	{[]byte("OggS"), "audio/ogg", KindAudio},
+}
+
+// Detect returns the content type and kind of an asset from its first bytes.
+// Only the first SniffLen bytes are considered.
+func Detect(data []byte) (contentType string, kind string) {
+	if len(data) > SniffLen {
+		data = data[:SniffLen]
+	}
+	for _, s := range signatures {
+		if bytes.HasPrefix(data, s.prefix) {
+			return s.contentType, s.kind
+		}
+	}
+	contentType = http.DetectContentType(data)
+	switch {
+	case strings.HasPrefix(contentType, "image/"):
+		return contentType, KindTexture
+	case strings.HasPrefix(contentType, "audio/"):
+		return contentType, KindAudio
+	}
+	return contentType, KindOther
+}
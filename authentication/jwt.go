@@ -1,6 +1,7 @@
 package authentication
 
 import (
+    "net/http"
     "os"
     "time"
     "github.com/golang-jwt/jwt/v5"
@@ -51,4 +52,13 @@ func ParseToken(tokenStr string) (*Claims, error) {
     }
 
     return nil, jwt.ErrTokenSignatureInvalid
-}
\ No newline at end of file
+}
+
+// RequestToken returns the JWT sent with a request, preferring the auth_token
+// cookie set by the web interface over the auth query parameter used in-world
+func RequestToken(r *http.Request) string {
+    if authCookie, err := r.Cookie("auth_token"); err == nil {
+        return authCookie.Value
+    }
+    return r.URL.Query().Get("auth")
+}
@@ -0,0 +1,100 @@
+package brson
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/andybalholm/brotli"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Header is the FrDT magic and version that prefixes every brson record
+var Header = []byte{70, 114, 68, 84, 0, 0, 0, 0, 3}
+
+// ordered returns a value with every map replaced by a key sorted bson.D.
+// Go maps marshal in random order, which would make the same document encode
+// to different bytes on every write.
+func ordered(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		return orderedMap(v)
+	case primitive.M:
+		return orderedMap(v)
+	case primitive.D:
+		d := make(primitive.D, len(v))
+		for i, e := range v {
+			d[i] = primitive.E{Key: e.Key, Value: ordered(e.Value)}
+		}
+		return d
+	case primitive.A:
+		a := make(primitive.A, len(v))
+		for i, e := range v {
+			a[i] = ordered(e)
+		}
+		return a
+	case []interface{}:
+		a := make(primitive.A, len(v))
+		for i, e := range v {
+			a[i] = ordered(e)
+		}
+		return a
+	}
+	return value
+}
+
+func orderedMap(m map[string]interface{}) primitive.D {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	d := make(primitive.D, len(keys))
+	for i, key := range keys {
+		d[i] = primitive.E{Key: key, Value: ordered(m[key])}
+	}
+	return d
+}
+
+// Write encodes a document as brotli compressed BSON behind the FrDT header.
+// The same document always encodes to the same bytes.
+func Write(doc map[string]interface{}) ([]byte, error) {
+	bsonData, err := bson.Marshal(orderedMap(doc))
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal BSON: %w", err)
+	}
+
+	var compressedBuf bytes.Buffer
+	writer := brotli.NewWriter(&compressedBuf)
+	if _, err := writer.Write(bsonData); err != nil {
+		return nil, fmt.Errorf("brotli compression failed: %w", err)
+	}
+	writer.Close()
+
+	final := append(append([]byte{}, Header...), compressedBuf.Bytes()...)
+	return final, nil
+}
+
+// Read decodes a brson record into a document
+func Read(data []byte) (map[string]any, error) {
+	if len(data) < len(Header) || !bytes.Equal(data[:len(Header)], Header) {
+		return nil, fmt.Errorf("invalid BRSON header")
+	}
+	// BRSON header is skipped
+	compressed := data[len(Header):]
+
+	br := brotli.NewReader(bytes.NewReader(compressed))
+	decompressed, err := io.ReadAll(br)
+	if err != nil {
+		return nil, fmt.Errorf("brotli decompression failed: %w", err)
+	}
+
+	var doc map[string]any
+	if err := bson.Unmarshal(decompressed, &doc); err != nil {
+		return nil, fmt.Errorf("bson unmarshal failed: %w", err)
+	}
+
+	return doc, nil
+}
@@ -0,0 +1,75 @@
+package brson
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ToJSON renders a document, or any value inside one, as indented canonical
+// extended JSON with sorted keys. Canonical JSON keeps BSON types such as
+// int32 and int64 apart, so FromJSON gives back the same document. Values
+// that aren't documents are wrapped as {"value": ...}.
+func ToJSON(value interface{}) ([]byte, error) {
+	value = ordered(value)
+	if _, ok := value.(primitive.D); !ok {
+		value = primitive.D{{Key: "value", Value: value}}
+	}
+	data, err := bson.MarshalExtJSON(value, true, false)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal JSON: %w", err)
+	}
+	var indented bytes.Buffer
+	if err := json.Indent(&indented, data, "", "  "); err != nil {
+		return nil, err
+	}
+	indented.WriteByte('\n')
+	return indented.Bytes(), nil
+}
+
+// FromJSON parses a document from canonical or relaxed extended JSON
+func FromJSON(data []byte) (map[string]interface{}, error) {
+	var doc map[string]interface{}
+	if err := bson.UnmarshalExtJSON(data, false, &doc); err != nil {
+		return nil, fmt.Errorf("invalid JSON document: %w", err)
+	}
+	return doc, nil
+}
+
+// ErrNoValue is wrapped by Select's errors for paths that select nothing
+var ErrNoValue = errors.New("path selects no value")
+
+// Select returns the value at a dot separated path such as
+// "Object.Children.0.Name", where numbers index into arrays. An empty path
+// selects the whole document.
+func Select(doc map[string]interface{}, path string) (interface{}, error) {
+	var value interface{} = doc
+	if path == "" {
+		return value, nil
+	}
+	for _, key := range strings.Split(path, ".") {
+		switch v := value.(type) {
+		case map[string]interface{}:
+			next, ok := v[key]
+			if !ok {
+				return nil, fmt.Errorf("%w: no field %q at %s", ErrNoValue, key, path)
+			}
+			value = next
+		case primitive.A:
+			index, err := strconv.Atoi(key)
+			if err != nil || index < 0 || index >= len(v) {
+				return nil, fmt.Errorf("%w: no element %q at %s", ErrNoValue, key, path)
+			}
+			value = v[index]
+		default:
+			return nil, fmt.Errorf("%w: %q is not inside a document or array at %s", ErrNoValue, key, path)
+		}
+	}
+	return value, nil
+}
@@ -0,0 +1,146 @@
+package brson
+
+import (
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// AssetScheme prefixes the asset URIs of records inside a resonitepackage
+const AssetScheme = "packdb:///"
+
+// Asset URIs only ever appear as the value of a component field, which is
+// stored as {"ID": ..., "Data": "<uri>"}. Slots form a tree below "Object",
+// each holding its components in {"Components": {"Data": [...]}} and its
+// children in "Children", while shared asset providers live in the top level
+// "Assets" list. Slot names and other slot level fields are never visited,
+// and a field only counts as a URI when its whole value is one, so text that
+// merely mentions a URI is left alone.
+
+// splitLocal separates the "@" Resonite puts in front of URIs it should
+// resolve locally from the URI itself
+func splitLocal(uri string) (string, string) {
+	if strings.HasPrefix(uri, "@") {
+		return "@", uri[1:]
+	}
+	return "", uri
+}
+
+// looksLikeUri reports whether a field value is a single URI
+func looksLikeUri(value string) bool {
+	return strings.Contains(value, "://") && strings.IndexAny(value, " \t\r\n") < 0
+}
+
+func elements(value interface{}) []interface{} {
+	switch v := value.(type) {
+	case []interface{}:
+		return v
+	case primitive.A:
+		return v
+	}
+	return nil
+}
+
+// walkField visits a field value, which may be a list or a struct of fields
+func walkField(value interface{}, visit func(uri string) string) {
+	field, ok := value.(map[string]interface{})
+	if !ok {
+		return
+	}
+	data, ok := field["Data"]
+	if !ok {
+		walkFields(field, visit)
+		return
+	}
+	switch v := data.(type) {
+	case string:
+		if looksLikeUri(v) {
+			field["Data"] = visit(v)
+		}
+	case map[string]interface{}:
+		walkFields(v, visit)
+	default:
+		for _, item := range elements(v) {
+			walkField(item, visit)
+		}
+	}
+}
+
+func walkFields(fields map[string]interface{}, visit func(uri string) string) {
+	for name, value := range fields {
+		if name != "ID" {
+			walkField(value, visit)
+		}
+	}
+}
+
+func walkComponents(components []interface{}, visit func(uri string) string) {
+	for _, component := range components {
+		if component, ok := component.(map[string]interface{}); ok {
+			if data, ok := component["Data"].(map[string]interface{}); ok {
+				walkFields(data, visit)
+			}
+		}
+	}
+}
+
+func walkSlot(value interface{}, visit func(uri string) string) {
+	slot, ok := value.(map[string]interface{})
+	if !ok {
+		return
+	}
+	if components, ok := slot["Components"].(map[string]interface{}); ok {
+		walkComponents(elements(components["Data"]), visit)
+	}
+	for _, child := range elements(slot["Children"]) {
+		walkSlot(child, visit)
+	}
+}
+
+// walkAssetUris calls visit for every URI stored in a component field of the
+// record and replaces it with the returned value
+func walkAssetUris(doc map[string]interface{}, visit func(uri string) string) {
+	walkSlot(doc["Object"], visit)
+	walkComponents(elements(doc["Assets"]), visit)
+}
+
+// AssetHash extracts the asset hash from a packdb:/// reference. Resonite
+// may append a file extension such as .meshx, which isn't part of the name
+// the asset is stored under and is dropped.
+func AssetHash(uri string) string {
+	_, uri = splitLocal(uri)
+	hash := strings.TrimPrefix(uri, AssetScheme)
+	if i := strings.IndexAny(hash, ".?#"); i >= 0 {
+		hash = hash[:i]
+	}
+	return hash
+}
+
+// AssetReferences returns the hashes of every packdb:/// asset the record
+// references, without extensions
+func AssetReferences(doc map[string]interface{}) map[string]bool {
+	refs := make(map[string]bool)
+	walkAssetUris(doc, func(uri string) string {
+		if _, plain := splitLocal(uri); strings.HasPrefix(plain, AssetScheme) {
+			refs[AssetHash(plain)] = true
+		}
+		return uri
+	})
+	return refs
+}
+
+// RewriteAssetUris replaces the prefix from with to on every asset URI in the
+// record that starts with it, and returns how many URIs were rewritten. A
+// leading "@" is kept.
+func RewriteAssetUris(doc map[string]interface{}, from string, to string) int {
+	rewritten := 0
+	walkAssetUris(doc, func(uri string) string {
+		local, plain := splitLocal(uri)
+		if !strings.HasPrefix(plain, from) {
+			return uri
+		}
+		rewritten++
+		return local + to + strings.TrimPrefix(plain, from)
+	})
+	return rewritten
+}
@@ -0,0 +1,103 @@
+package brson
+
+import (
+	"os"
+	"reflect"
+	"testing"
+)
+
+const (
+	nameHash      = "1111111111111111111111111111111111111111111111111111111111111111"
+	textHash      = "2222222222222222222222222222222222222222222222222222222222222222"
+	meshHash      = "3333333333333333333333333333333333333333333333333333333333333333"
+	textureHash   = "4444444444444444444444444444444444444444444444444444444444444444"
+	mentionedText = "Textures live at packdb:///" + textHash + " now"
+)
+
+// readFixture loads testdata/record.json and passes it through Write and Read,
+// so the walk sees the types of a decoded record
+func readFixture(t *testing.T) map[string]interface{} {
+	t.Helper()
+	data, err := os.ReadFile("testdata/record.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc, err := FromJSON(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	record, err := Write(doc)
+	if err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	doc, err = Read(record)
+	if err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+	return doc
+}
+
+func selectString(t *testing.T, doc map[string]interface{}, path string) string {
+	t.Helper()
+	value, err := Select(doc, path)
+	if err != nil {
+		t.Fatalf("Select(%q): %v", path, err)
+	}
+	s, ok := value.(string)
+	if !ok {
+		t.Fatalf("Select(%q) = %T, want string", path, value)
+	}
+	return s
+}
+
+func TestAssetHash(t *testing.T) {
+	tests := map[string]string{
+		"packdb:///" + meshHash:             meshHash,
+		"packdb:///" + meshHash + ".meshx":  meshHash,
+		"@packdb:///" + meshHash + ".meshx": meshHash,
+		"@packdb:///" + meshHash:            meshHash,
+		"packdb:///" + meshHash + "?lod=1":  meshHash,
+		"@packdb:///" + meshHash + "#frag":  meshHash,
+	}
+	for uri, want := range tests {
+		if got := AssetHash(uri); got != want {
+			t.Errorf("AssetHash(%q) = %q, want %q", uri, got, want)
+		}
+	}
+}
+
+func TestAssetReferences(t *testing.T) {
+	doc := readFixture(t)
+	want := map[string]bool{
+		meshHash:    true,
+		textureHash: true,
+	}
+	if got := AssetReferences(doc); !reflect.DeepEqual(got, want) {
+		t.Errorf("AssetReferences = %v, want %v", got, want)
+	}
+}
+
+func TestRewriteAssetUris(t *testing.T) {
+	doc := readFixture(t)
+	if n := RewriteAssetUris(doc, AssetScheme, "https://assets.example/"); n != 2 {
+		t.Errorf("RewriteAssetUris rewrote %d URIs, want 2", n)
+	}
+
+	tests := map[string]string{
+		"Object.Children.0.Components.Data.0.Data.URL.Data": "@https://assets.example/" + meshHash + ".meshx",
+		"Assets.0.Data.URL.Data":                            "https://assets.example/" + textureHash + ".webp",
+		"Assets.0.Data.Fallback.Data":                       "resdb:///5555555555555555555555555555555555555555555555555555555555555555.webp",
+		// Text mentioning a URI and slot level fields are left alone
+		"Object.Components.Data.0.Data.Text.Data": mentionedText,
+		"Object.Name.Data":                        "packdb:///" + nameHash,
+	}
+	for path, want := range tests {
+		if got := selectString(t, doc, path); got != want {
+			t.Errorf("%s = %q, want %q", path, got, want)
+		}
+	}
+
+	if refs := AssetReferences(doc); len(refs) != 0 {
+		t.Errorf("AssetReferences after rewrite = %v, want none", refs)
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"resonite-file-provider/assetgc"
+	"resonite-file-provider/config"
+	"resonite-file-provider/storage"
+	"resonite-file-provider/upload"
+	"time"
+)
+
+// gcGrace is the configured garbage collection grace period, a day by default
+func gcGrace() time.Duration {
+	if grace := config.GetConfig().GC.Grace; grace > 0 {
+		return grace
+	}
+	return 24 * time.Hour
+}
+
+// runCommand runs a one-off maintenance command instead of the server
+func runCommand(name string, args []string) error {
+	switch name {
+	case "migrate-asset-urls":
+		flags := flag.NewFlagSet(name, flag.ExitOnError)
+		from := flags.String("from", upload.LegacyAssetUrl(), "asset URL prefix that older versions baked into records")
+		dryRun := flags.Bool("dry-run", false, "only list the records that would be rewritten")
+		flags.Parse(args)
+
+		migrated, err := upload.MigrateRecordUrls(*from, *dryRun)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%d records referencing %s\n", migrated, *from)
+		return nil
+	case "migrate-layout":
+		flags := flag.NewFlagSet(name, flag.ExitOnError)
+		dryRun := flags.Bool("dry-run", false, "only list the files that would be moved")
+		flags.Parse(args)
+
+		moved, err := storage.MigrateLayout(storage.Assets.Backend, *dryRun, func(format string, args ...any) {
+			fmt.Printf("[MIGRATE] "+format+"\n", args...)
+		})
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%d files in the flat layout\n", moved)
+		return nil
+	case "gc":
+		flags := flag.NewFlagSet(name, flag.ExitOnError)
+		dryRun := flags.Bool("dry-run", false, "only report what would be deleted")
+		grace := flags.Duration("grace", gcGrace(), "minimum age of unreferenced files before they are deleted")
+		verify := flags.Bool("verify", false, "re-hash stored assets to detect corruption")
+		flags.Parse(args)
+
+		report, err := assetgc.Run(assetgc.Options{DryRun: *dryRun, Grace: *grace, Verify: *verify})
+		if err != nil {
+			return err
+		}
+		action := "Deleted"
+		if *dryRun {
+			action = "Would delete"
+		}
+		for _, name := range report.DeletedFiles {
+			fmt.Println(action, "unreferenced file", name)
+		}
+		for _, hash := range report.DeletedRows {
+			fmt.Println(action, "unused asset row", hash)
+		}
+		for _, failure := range report.FailedFiles {
+			fmt.Println("Failed to delete file", failure)
+		}
+		for _, failure := range report.FailedRows {
+			fmt.Println("Failed to delete asset row", failure)
+		}
+		for _, hash := range report.Missing {
+			fmt.Println("Missing file for asset", hash)
+		}
+		for _, hash := range report.Corrupt {
+			fmt.Println("Corrupt asset", hash)
+		}
+		fmt.Println(report.String())
+		return nil
+	case "record-json":
+		flags := flag.NewFlagSet(name, flag.ExitOnError)
+		itemId := flags.Int("item", 0, "ID of the item whose record to decode")
+		path := flags.String("path", "", "only print the value at this dot separated path, e.g. Object.Children.0")
+		flags.Parse(args)
+
+		data, err := upload.RecordJSON(*itemId, *path)
+		if err != nil {
+			return err
+		}
+		os.Stdout.Write(data)
+		return nil
+	case "record-load":
+		flags := flag.NewFlagSet(name, flag.ExitOnError)
+		itemId := flags.Int("item", 0, "ID of the item whose record to replace")
+		file := flags.String("file", "-", "JSON document to store as the record, - for stdin")
+		flags.Parse(args)
+
+		var data []byte
+		var err error
+		if *file == "-" {
+			data, err = io.ReadAll(os.Stdin)
+		} else {
+			data, err = os.ReadFile(*file)
+		}
+		if err != nil {
+			return err
+		}
+		recordHash, err := upload.ReplaceRecord(*itemId, data)
+		if err != nil {
+			return err
+		}
+		fmt.Println("Stored record", recordHash, "for item", *itemId)
+		return nil
+	default:
+		return fmt.Errorf("unknown command %q", name)
+	}
+}
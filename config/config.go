@@ -1,12 +1,56 @@
 package config
 
 import (
+	"time"
+
 	"github.com/BurntSushi/toml"
 )
 
 type Config struct {
 	Database DatabaseConfig
 	Server   ServerConfig
+	Storage  StorageConfig
+	GC       GCConfig
+	Quota    QuotaConfig
+}
+
+type QuotaConfig struct {
+	// DefaultUserBytes limits the storage of all inventories a user owns,
+	// unless an admin set a quota for that user. Zero means unlimited.
+	DefaultUserBytes int64
+	// DefaultInventoryBytes limits the storage of each inventory. Zero means
+	// unlimited.
+	DefaultInventoryBytes int64
+}
+
+type GCConfig struct {
+	// Interval between scheduled garbage collection runs, disabled when zero
+	Interval time.Duration
+	// Grace is how old an unreferenced file must be before it is deleted
+	Grace time.Duration
+	// Verify re-hashes stored assets on every run to detect corruption
+	Verify bool
+}
+
+type StorageConfig struct {
+	// Backend is "local" (files in Server.AssetsPath) or "s3"
+	Backend string
+	S3      S3Config
+}
+
+type S3Config struct {
+	// Endpoint of an S3 compatible service, e.g. "http://minio:9000".
+	// Defaults to AWS S3 in Region.
+	Endpoint  string
+	Region    string
+	Bucket    string
+	AccessKey string
+	SecretKey string
+	// PathStyle addresses the bucket in the path instead of the host name,
+	// which MinIO and most self-hosted services need
+	PathStyle bool
+	// Prefix is prepended to every object key
+	Prefix string
 }
 
 type ServerConfig struct {
@@ -14,6 +58,9 @@ type ServerConfig struct {
 	Port       int
 	ItemsPath  string
 	AssetsPath string
+	// PublicBaseURL is the address clients use to reach the server, e.g.
+	// "https://files.example.com". When empty it is derived from each request.
+	PublicBaseURL string
 }
 
 type DatabaseConfig struct {
@@ -4,6 +4,7 @@ import (
 	"database/sql"
 	"fmt"
 	"resonite-file-provider/config"
+	"strings"
 	"time"
 
 	_ "github.com/go-sql-driver/mysql"
@@ -11,6 +12,18 @@ import (
 
 var Db *sql.DB
 
+// Queryer is implemented by both *sql.DB and *sql.Tx, so helpers can read
+// inside or outside of a transaction
+type Queryer interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// Placeholders returns a list of n SQL placeholders for an IN clause
+func Placeholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?, ", n), ", ")
+}
+
 func Connect() {
 	cfg := config.GetConfig().Database
 	dsn := fmt.Sprintf("%s:%s@tcp(%s:%d)/%s",
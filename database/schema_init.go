@@ -1,7 +1,6 @@
 package database
 
 import (
-	"database/sql"
 	"fmt"
 )
 
@@ -28,6 +27,208 @@ func InitializeSchema() error {
 		return fmt.Errorf("foreign key verification failed: %w", err)
 	}
 	
+	// Bring databases created from an older schema file up to date
+	if err := addMissingColumns(); err != nil {
+		return fmt.Errorf("schema migration failed: %w", err)
+	}
+	if err := fixAssetTagsReference(); err != nil {
+		return fmt.Errorf("schema migration failed: %w", err)
+	}
+	if err := mergeDuplicateTags(); err != nil {
+		return fmt.Errorf("schema migration failed: %w", err)
+	}
+	if err := addMissingIndexes(); err != nil {
+		return fmt.Errorf("schema migration failed: %w", err)
+	}
+	if err := allowRootFolders(); err != nil {
+		return fmt.Errorf("schema migration failed: %w", err)
+	}
+	
+	return nil
+}
+
+// addMissingColumns adds columns introduced after the initial schema to
+// existing databases, so deployments don't need to be recreated
+func addMissingColumns() error {
+	columns := []struct {
+		table      string
+		column     string
+		definition string
+	}{
+		// The default user setup has always written access_level, but older
+		// schema files never defined it. Folder permission checks
+		// (query.CheckFolderAccess) read it on every edit.
+		{"users_inventories", "access_level", "enum('owner','editor','viewer') NOT NULL DEFAULT 'owner'"},
+		{"Users", "is_admin", "tinyint(1) NOT NULL DEFAULT 0"},
+		{"Users", "quota_bytes", "bigint(20) DEFAULT NULL"},
+		{"Assets", "size", "bigint(20) NOT NULL DEFAULT 0"},
+		{"Items", "thumbnail_url", "text DEFAULT NULL"},
+		{"Assets", "content_type", "varchar(255) DEFAULT NULL"},
+		{"Assets", "kind", "enum('texture','mesh','audio','record','other') DEFAULT NULL"},
+		{"Folders", "created_at", "timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP"},
+		{"Items", "created_at", "timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP"},
+	}
+	
+	for _, c := range columns {
+		var count int
+		err := Db.QueryRow(`
+			SELECT COUNT(*) 
+			FROM information_schema.COLUMNS 
+			WHERE TABLE_SCHEMA = DATABASE() 
+			AND TABLE_NAME = ? 
+			AND COLUMN_NAME = ?
+		`, c.table, c.column).Scan(&count)
+		
+		if err != nil {
+			return fmt.Errorf("failed to check column %s on table %s: %w", c.column, c.table, err)
+		}
+		
+		if count > 0 {
+			continue
+		}
+		
+		if _, err := Db.Exec(fmt.Sprintf("ALTER TABLE `%s` ADD COLUMN `%s` %s", c.table, c.column, c.definition)); err != nil {
+			return fmt.Errorf("failed to add column %s to table %s: %w", c.column, c.table, err)
+		}
+	}
+	
+	return nil
+}
+
+// addMissingIndexes adds indexes introduced after the initial schema to
+// existing databases. Building a FULLTEXT index on a large table can take a
+// while, so the first start after upgrading may be slow.
+func addMissingIndexes() error {
+	indexes := []struct {
+		table   string
+		index   string
+		kind    string
+		columns string
+	}{
+		{"Items", "Items_name_search", "FULLTEXT INDEX", "`name`"},
+		{"Folders", "Folders_name_search", "FULLTEXT INDEX", "`name`"},
+		// Tag names are at most 255 bytes, so the prefix covers all of them
+		{"Tags", "Tags_name", "UNIQUE INDEX", "`name`(255)"},
+	}
+	
+	for _, idx := range indexes {
+		var count int
+		err := Db.QueryRow(`
+			SELECT COUNT(*) 
+			FROM information_schema.STATISTICS 
+			WHERE TABLE_SCHEMA = DATABASE() 
+			AND TABLE_NAME = ? 
+			AND INDEX_NAME = ?
+		`, idx.table, idx.index).Scan(&count)
+		
+		if err != nil {
+			return fmt.Errorf("failed to check index %s on table %s: %w", idx.index, idx.table, err)
+		}
+		
+		if count > 0 {
+			continue
+		}
+		
+		if _, err := Db.Exec(fmt.Sprintf("ALTER TABLE `%s` ADD %s `%s` (%s)", idx.table, idx.kind, idx.index, idx.columns)); err != nil {
+			return fmt.Errorf("failed to add index %s to table %s: %w", idx.index, idx.table, err)
+		}
+	}
+	
+	return nil
+}
+
+// mergeDuplicateTags folds tags sharing a name into the oldest of them, so
+// the unique Tags_name index can be added. Older versions looked a tag up
+// before inserting it, which could create a tag twice.
+func mergeDuplicateTags() error {
+	// Names are compared like the index compares them, by their first 255
+	// characters
+	duplicates := "SELECT LEFT(`name`, 255) AS `name`, MIN(`id`) AS `keep_id` FROM `Tags` GROUP BY LEFT(`name`, 255) HAVING COUNT(*) > 1"
+	for _, table := range []string{"item_tags", "asset_tags"} {
+		_, err := Db.Exec(fmt.Sprintf(
+			"UPDATE `%s` x INNER JOIN `Tags` t ON t.`id` = x.`tag_id` INNER JOIN (%s) d ON d.`name` = LEFT(t.`name`, 255) SET x.`tag_id` = d.`keep_id` WHERE x.`tag_id` <> d.`keep_id`",
+			table, duplicates))
+		if err != nil {
+			return fmt.Errorf("failed to merge duplicate tags in %s: %w", table, err)
+		}
+	}
+	result, err := Db.Exec(fmt.Sprintf(
+		"DELETE t FROM `Tags` t INNER JOIN (%s) d ON d.`name` = LEFT(t.`name`, 255) WHERE t.`id` <> d.`keep_id`",
+		duplicates))
+	if err != nil {
+		return fmt.Errorf("failed to remove duplicate tags: %w", err)
+	}
+	if removed, _ := result.RowsAffected(); removed > 0 {
+		fmt.Printf("Merged %d duplicate tags\n", removed)
+	}
+	// Items tagged with two of the merged tags now hold the tag twice
+	if _, err := Db.Exec("DELETE a FROM `item_tags` a INNER JOIN `item_tags` b ON b.`tag_id` = a.`tag_id` AND b.`item_id` = a.`item_id` AND b.`id` < a.`id`"); err != nil {
+		return fmt.Errorf("failed to remove duplicate item tags: %w", err)
+	}
+	
+	return nil
+}
+
+// fixAssetTagsReference points asset_tags_ibfk_2 at Tags. Older schema
+// files had it reference item_tags, so asset tags couldn't name a tag.
+// Rows whose tag doesn't exist in Tags can't be kept under the new
+// constraint and are dropped.
+func fixAssetTagsReference() error {
+	var referenced string
+	err := Db.QueryRow(`
+		SELECT REFERENCED_TABLE_NAME 
+		FROM information_schema.REFERENTIAL_CONSTRAINTS 
+		WHERE CONSTRAINT_SCHEMA = DATABASE() 
+		AND TABLE_NAME = 'asset_tags' 
+		AND CONSTRAINT_NAME = 'asset_tags_ibfk_2'
+	`).Scan(&referenced)
+	if err != nil {
+		return fmt.Errorf("failed to check constraint asset_tags_ibfk_2: %w", err)
+	}
+	if referenced == "Tags" {
+		return nil
+	}
+	
+	if _, err := Db.Exec("ALTER TABLE `asset_tags` DROP FOREIGN KEY `asset_tags_ibfk_2`"); err != nil {
+		return fmt.Errorf("failed to drop constraint asset_tags_ibfk_2: %w", err)
+	}
+	result, err := Db.Exec("DELETE FROM `asset_tags` WHERE `tag_id` NOT IN (SELECT `id` FROM `Tags`)")
+	if err != nil {
+		return fmt.Errorf("failed to remove asset tags without a tag: %w", err)
+	}
+	if removed, _ := result.RowsAffected(); removed > 0 {
+		fmt.Printf("Removed %d asset tags referring to missing tags\n", removed)
+	}
+	if _, err := Db.Exec("ALTER TABLE `asset_tags` ADD CONSTRAINT `asset_tags_ibfk_2` FOREIGN KEY (`tag_id`) REFERENCES `Tags` (`id`)"); err != nil {
+		return fmt.Errorf("failed to add constraint asset_tags_ibfk_2: %w", err)
+	}
+	
+	return nil
+}
+
+// allowRootFolders makes Folders.parent_folder_id nullable. Root folders are
+// the ones without a parent, but older schema files declared the column
+// NOT NULL, which strict SQL modes reject when an inventory is created.
+func allowRootFolders() error {
+	var nullable string
+	err := Db.QueryRow(`
+		SELECT IS_NULLABLE 
+		FROM information_schema.COLUMNS 
+		WHERE TABLE_SCHEMA = DATABASE() 
+		AND TABLE_NAME = 'Folders' 
+		AND COLUMN_NAME = 'parent_folder_id'
+	`).Scan(&nullable)
+	if err != nil {
+		return fmt.Errorf("failed to check column parent_folder_id on table Folders: %w", err)
+	}
+	if nullable == "YES" {
+		return nil
+	}
+	
+	if _, err := Db.Exec("ALTER TABLE `Folders` MODIFY COLUMN `parent_folder_id` int(11) DEFAULT NULL"); err != nil {
+		return fmt.Errorf("failed to make parent_folder_id nullable: %w", err)
+	}
+	
 	return nil
 }
 
@@ -6,11 +6,13 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"resonite-file-provider/assetgc"
 	"resonite-file-provider/assethost"
 	"resonite-file-provider/authentication"
 	"resonite-file-provider/config"
 	"resonite-file-provider/database"
 	"resonite-file-provider/query"
+	"resonite-file-provider/storage"
 	"resonite-file-provider/upload"
 )
 
@@ -23,6 +25,18 @@ func main() {
 		log.Fatalf("Schema verification failed: %v", err)
 	}
 
+	if err := storage.Init(); err != nil {
+		log.Fatalf("Storage setup failed: %v", err)
+	}
+
+	// Maintenance commands run against the database and exit
+	if len(os.Args) > 1 {
+		if err := runCommand(os.Args[1], os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	query.AddSearchListeners()    // AnimX API endpoints for VR client
 	query.AddJSONAPIListeners()   // JSON API endpoints for web interface
 	authentication.AddAuthListeners()
@@ -38,6 +52,10 @@ func main() {
 		},
 	}
 
+	if interval := config.GetConfig().GC.Interval; interval > 0 {
+		go assetgc.Schedule(interval, assetgc.Options{Grace: gcGrace(), Verify: config.GetConfig().GC.Verify})
+	}
+
 	go upload.StartWebServer()
 	
 	// Check if TLS certificates exist
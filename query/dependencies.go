@@ -0,0 +1,210 @@
+package query
+
+import (
+	"encoding/json"
+	"net/http"
+	"resonite-file-provider/authentication"
+	"resonite-file-provider/database"
+	"strconv"
+)
+
+// defaultSubtreeAssetLimit is how many of the largest assets a subtree
+// report lists unless the caller asks for another number
+const defaultSubtreeAssetLimit = 50
+
+type AssetItemsResponse struct {
+	Success bool            `json:"success"`
+	Data    []AssetItemInfo `json:"data"`
+}
+
+type AssetItemInfo struct {
+	ID          int    `json:"id"`
+	Name        string `json:"name"`
+	FolderID    int    `json:"folderId"`
+	InventoryID int    `json:"inventoryId"`
+}
+
+// SubtreeAssetsResponse sums the assets used by the items of a folder and
+// its descendants. UniqueBytes are used only inside the subtree and would be
+// freed by deleting it, SharedBytes are also used by items elsewhere.
+type SubtreeAssetsResponse struct {
+	Success     bool               `json:"success"`
+	FolderID    int                `json:"folderId"`
+	AssetCount  int                `json:"assetCount"`
+	TotalBytes  int64              `json:"totalBytes"`
+	UniqueBytes int64              `json:"uniqueBytes"`
+	SharedBytes int64              `json:"sharedBytes"`
+	Assets      []SubtreeAssetItem `json:"assets"`
+}
+
+// SubtreeAssetItem is an asset used in a subtree. ItemCount counts the items
+// inside the subtree that use it.
+type SubtreeAssetItem struct {
+	Hash      string `json:"hash"`
+	Size      int64  `json:"size"`
+	Kind      string `json:"kind"`
+	ItemCount int    `json:"itemCount"`
+	Shared    bool   `json:"shared"`
+}
+
+// FolderSubtree returns the ids of a folder and all of its descendants,
+// parents before their children
+func FolderSubtree(q database.Queryer, folderId int) ([]int, error) {
+	folderIds := []int{folderId}
+	// Children are appended as they are found, so the loop walks every level
+	for i := 0; i < len(folderIds); i++ {
+		rows, err := q.Query("SELECT id FROM Folders WHERE parent_folder_id = ?", folderIds[i])
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var childId int
+			if err := rows.Scan(&childId); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			folderIds = append(folderIds, childId)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+	return folderIds, nil
+}
+
+// getAssetItemsJSON handles GET /api/assets/items, listing the items in the
+// caller's inventories that use an asset
+func getAssetItemsJSON(w http.ResponseWriter, r *http.Request) {
+	hash := r.URL.Query().Get("hash")
+	if hash == "" {
+		http.Error(w, "hash is not specified", http.StatusBadRequest)
+		return
+	}
+
+	claims, err := authentication.ParseToken(authentication.RequestToken(r))
+	if err != nil {
+		http.Error(w, "Auth token invalid or missing", http.StatusUnauthorized)
+		return
+	}
+
+	// Set JSON content type
+	w.Header().Set("Content-Type", "application/json")
+
+	rows, err := database.Db.Query(`
+		SELECT DISTINCT it.id, it.name, it.folder_id, f.inventory_id
+		FROM Items it
+		INNER JOIN Folders f ON f.id = it.folder_id
+		INNER JOIN users_inventories ui ON ui.inventory_id = f.inventory_id
+		INNER JOIN `+"`hash-usage`"+` hu ON hu.item_id = it.id
+		INNER JOIN Assets a ON a.id = hu.asset_id
+		WHERE ui.user_id = ? AND a.hash = ?
+		ORDER BY it.id
+	`, claims.UID, hash)
+	if err != nil {
+		json.NewEncoder(w).Encode(AssetItemsResponse{Success: false})
+		return
+	}
+	defer rows.Close()
+
+	items := []AssetItemInfo{}
+	for rows.Next() {
+		var item AssetItemInfo
+		if err := rows.Scan(&item.ID, &item.Name, &item.FolderID, &item.InventoryID); err != nil {
+			json.NewEncoder(w).Encode(AssetItemsResponse{Success: false})
+			return
+		}
+		items = append(items, item)
+	}
+
+	json.NewEncoder(w).Encode(AssetItemsResponse{Success: true, Data: items})
+}
+
+// getSubtreeAssetsJSON handles GET /api/folders/assets, summing the assets
+// used below a folder and listing the largest of them
+func getSubtreeAssetsJSON(w http.ResponseWriter, r *http.Request) {
+	folderId, err := strconv.Atoi(r.URL.Query().Get("folderId"))
+	if err != nil {
+		http.Error(w, "folderId is either not specified or is invalid", http.StatusBadRequest)
+		return
+	}
+	limit := defaultSubtreeAssetLimit
+	if value := r.URL.Query().Get("limit"); value != "" {
+		if limit, err = strconv.Atoi(value); err != nil || limit < 0 {
+			http.Error(w, "limit must be a non-negative number", http.StatusBadRequest)
+			return
+		}
+	}
+
+	claims, err := authentication.ParseToken(authentication.RequestToken(r))
+	if err != nil {
+		http.Error(w, "Auth token invalid or missing", http.StatusUnauthorized)
+		return
+	}
+	if allowed, err := CheckFolderAccess(folderId, claims.UID, "viewer"); err != nil || !allowed {
+		http.Error(w, "You don't have access to this folder", http.StatusForbidden)
+		return
+	}
+
+	// Set JSON content type
+	w.Header().Set("Content-Type", "application/json")
+
+	folderIds, err := FolderSubtree(database.Db, folderId)
+	if err != nil {
+		json.NewEncoder(w).Encode(SubtreeAssetsResponse{Success: false})
+		return
+	}
+	args := make([]interface{}, 0, 2*len(folderIds))
+	for _, id := range folderIds {
+		args = append(args, id)
+	}
+	args = append(args, args...)
+	in := database.Placeholders(len(folderIds))
+
+	// An asset is shared when an item outside the subtree uses it too
+	rows, err := database.Db.Query(`
+		SELECT a.hash, a.size, COALESCE(a.kind, ''), COUNT(DISTINCT hu.item_id),
+			EXISTS (
+				SELECT 1
+				FROM `+"`hash-usage`"+` other
+				INNER JOIN Items oit ON oit.id = other.item_id
+				WHERE other.asset_id = a.id AND oit.folder_id NOT IN (`+in+`)
+			)
+		FROM Assets a
+		INNER JOIN `+"`hash-usage`"+` hu ON hu.asset_id = a.id
+		INNER JOIN Items it ON it.id = hu.item_id
+		WHERE it.folder_id IN (`+in+`)
+		GROUP BY a.id, a.hash, a.size, a.kind
+		ORDER BY a.size DESC, a.hash
+	`, args...)
+	if err != nil {
+		json.NewEncoder(w).Encode(SubtreeAssetsResponse{Success: false})
+		return
+	}
+	defer rows.Close()
+
+	response := SubtreeAssetsResponse{
+		Success:  true,
+		FolderID: folderId,
+		Assets:   []SubtreeAssetItem{},
+	}
+	for rows.Next() {
+		var asset SubtreeAssetItem
+		if err := rows.Scan(&asset.Hash, &asset.Size, &asset.Kind, &asset.ItemCount, &asset.Shared); err != nil {
+			json.NewEncoder(w).Encode(SubtreeAssetsResponse{Success: false})
+			return
+		}
+		response.AssetCount++
+		response.TotalBytes += asset.Size
+		if asset.Shared {
+			response.SharedBytes += asset.Size
+		} else {
+			response.UniqueBytes += asset.Size
+		}
+		if len(response.Assets) < limit {
+			response.Assets = append(response.Assets, asset)
+		}
+	}
+
+	json.NewEncoder(w).Encode(response)
+}
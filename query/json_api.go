@@ -26,10 +26,14 @@ type InventoryRootResponse struct {
 	RootFolderId int  `json:"rootFolderId"`
 }
 
+// FoldersResponse lists a page of folders. Total counts the folders across
+// all pages and NextCursor is empty on the last page.
 type FoldersResponse struct {
-	Success bool                `json:"success"`
-	Data    []FolderListItem    `json:"data"`
-	Parent  *ParentFolderInfo   `json:"parent,omitempty"`
+	Success    bool              `json:"success"`
+	Data       []FolderListItem  `json:"data"`
+	Parent     *ParentFolderInfo `json:"parent,omitempty"`
+	Total      int               `json:"total"`
+	NextCursor string            `json:"nextCursor"`
 }
 
 type FolderListItem struct {
@@ -42,22 +46,68 @@ type ParentFolderInfo struct {
 	Name string `json:"name"`
 }
 
+// ItemsResponse lists a page of items. Total counts the items across all
+// pages and NextCursor is empty on the last page.
 type ItemsResponse struct {
-	Success bool             `json:"success"`
-	Data    []ItemListItem   `json:"data"`
+	Success    bool           `json:"success"`
+	Data       []ItemListItem `json:"data"`
+	Total      int            `json:"total"`
+	NextCursor string         `json:"nextCursor"`
 }
 
+// ItemListItem describes an item. ThumbnailURL is empty for items without
+// a thumbnail.
 type ItemListItem struct {
-	ID   int    `json:"id"`
-	Name string `json:"name"`
-	URL  string `json:"url"`
+	ID           int    `json:"id"`
+	Name         string `json:"name"`
+	URL          string `json:"url"`
+	ThumbnailURL string `json:"thumbnailUrl"`
 }
 
+// FolderContentsResponse lists a page of a folder's subfolders and items,
+// each paginated with its own cursor
 type FolderContentsResponse struct {
-	Success bool             `json:"success"`
-	Folders []FolderListItem `json:"folders"`
-	Items   []ItemListItem   `json:"items"`
-	Parent  *ParentFolderInfo `json:"parent,omitempty"`
+	Success           bool              `json:"success"`
+	Folders           []FolderListItem  `json:"folders"`
+	Items             []ItemListItem    `json:"items"`
+	Parent            *ParentFolderInfo `json:"parent,omitempty"`
+	FoldersTotal      int               `json:"foldersTotal"`
+	FoldersNextCursor string            `json:"foldersNextCursor"`
+	ItemsTotal        int               `json:"itemsTotal"`
+	ItemsNextCursor   string            `json:"itemsNextCursor"`
+}
+
+type UsageResponse struct {
+	Success     bool                 `json:"success"`
+	User        UsageInfo            `json:"user"`
+	Inventories []InventoryUsageItem `json:"inventories"`
+}
+
+// UsageInfo reports used storage in bytes. QuotaBytes is 0 when unlimited.
+type UsageInfo struct {
+	UsedBytes  int64 `json:"usedBytes"`
+	QuotaBytes int64 `json:"quotaBytes"`
+}
+
+type InventoryUsageItem struct {
+	ID         int    `json:"id"`
+	Name       string `json:"name"`
+	UsedBytes  int64  `json:"usedBytes"`
+	QuotaBytes int64  `json:"quotaBytes"`
+}
+
+type ItemAssetsResponse struct {
+	Success bool            `json:"success"`
+	Data    []AssetListItem `json:"data"`
+}
+
+// AssetListItem describes a stored asset. ContentType and Kind are empty for
+// assets stored before they were recorded.
+type AssetListItem struct {
+	Hash        string `json:"hash"`
+	Size        int64  `json:"size"`
+	ContentType string `json:"contentType"`
+	Kind        string `json:"kind"`
 }
 
 // Handler for JSON API endpoints for web interface
@@ -180,11 +230,17 @@ func listFoldersJSON(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	
+	opts, err := parseListOptions(r.URL.Query(), "cursor")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	
 	// Set JSON content type
 	w.Header().Set("Content-Type", "application/json")
 	
 	// Get child folders
-	childFolders, err := database.Db.Query("SELECT id, name FROM Folders WHERE parent_folder_id = ?", folderId)
+	childFolders, total, nextCursor, err := listChildFolders(folderId, opts)
 	if err != nil {
 		response := FoldersResponse{
 			Success: false,
@@ -193,16 +249,12 @@ func listFoldersJSON(w http.ResponseWriter, r *http.Request) {
 		json.NewEncoder(w).Encode(response)
 		return
 	}
-	defer childFolders.Close()
 	
 	var folders []FolderListItem
-	for childFolders.Next() {
-		var id int
-		var name string
-		childFolders.Scan(&id, &name)
+	for _, folder := range childFolders {
 		folders = append(folders, FolderListItem{
-			ID:   id,
-			Name: name,
+			ID:   folder.ID,
+			Name: folder.Name,
 		})
 	}
 	
@@ -226,9 +278,11 @@ func listFoldersJSON(w http.ResponseWriter, r *http.Request) {
 	}
 	
 	response := FoldersResponse{
-		Success: true,
-		Data:    folders,
-		Parent:  parentInfo,
+		Success:    true,
+		Data:       folders,
+		Parent:     parentInfo,
+		Total:      total,
+		NextCursor: nextCursor,
 	}
 	
 	json.NewEncoder(w).Encode(response)
@@ -271,11 +325,17 @@ func listItemsJSON(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	
+	opts, err := parseListOptions(r.URL.Query(), "cursor")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	
 	// Set JSON content type
 	w.Header().Set("Content-Type", "application/json")
 	
 	// Get items
-	items, err := database.Db.Query("SELECT id, name, url FROM Items WHERE folder_id = ?", folderId)
+	items, total, nextCursor, err := listChildItems(folderId, opts)
 	if err != nil {
 		response := ItemsResponse{
 			Success: false,
@@ -284,24 +344,22 @@ func listItemsJSON(w http.ResponseWriter, r *http.Request) {
 		json.NewEncoder(w).Encode(response)
 		return
 	}
-	defer items.Close()
 	
 	var itemList []ItemListItem
-	for items.Next() {
-		var id int
-		var name string
-		var url string
-		items.Scan(&id, &name, &url)
+	for _, item := range items {
 		itemList = append(itemList, ItemListItem{
-			ID:   id,
-			Name: name,
-			URL:  "assets/" + url,
+			ID:           item.ID,
+			Name:         item.Name,
+			URL:          "assets/" + item.URL,
+			ThumbnailURL: thumbnailPath(item.Thumbnail),
 		})
 	}
 	
 	response := ItemsResponse{
-		Success: true,
-		Data:    itemList,
+		Success:    true,
+		Data:       itemList,
+		Total:      total,
+		NextCursor: nextCursor,
 	}
 	
 	json.NewEncoder(w).Encode(response)
@@ -344,11 +402,22 @@ func listFolderContentsJSON(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	
+	folderOpts, err := parseListOptions(r.URL.Query(), "folderCursor")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	itemOpts, err := parseListOptions(r.URL.Query(), "itemCursor")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	
 	// Set JSON content type
 	w.Header().Set("Content-Type", "application/json")
 	
 	// Get subfolders
-	childFolders, err := database.Db.Query("SELECT id, name FROM Folders WHERE parent_folder_id = ?", folderId)
+	childFolders, foldersTotal, foldersNextCursor, err := listChildFolders(folderId, folderOpts)
 	if err != nil {
 		response := FolderContentsResponse{
 			Success: false,
@@ -358,21 +427,17 @@ func listFolderContentsJSON(w http.ResponseWriter, r *http.Request) {
 		json.NewEncoder(w).Encode(response)
 		return
 	}
-	defer childFolders.Close()
 	
 	var folders []FolderListItem
-	for childFolders.Next() {
-		var id int
-		var name string
-		childFolders.Scan(&id, &name)
+	for _, folder := range childFolders {
 		folders = append(folders, FolderListItem{
-			ID:   id,
-			Name: name,
+			ID:   folder.ID,
+			Name: folder.Name,
 		})
 	}
 	
 	// Get items
-	items, err := database.Db.Query("SELECT id, name, url FROM Items WHERE folder_id = ?", folderId)
+	items, itemsTotal, itemsNextCursor, err := listChildItems(folderId, itemOpts)
 	if err != nil {
 		response := FolderContentsResponse{
 			Success: false,
@@ -382,18 +447,14 @@ func listFolderContentsJSON(w http.ResponseWriter, r *http.Request) {
 		json.NewEncoder(w).Encode(response)
 		return
 	}
-	defer items.Close()
 	
 	var itemList []ItemListItem
-	for items.Next() {
-		var id int
-		var name string
-		var url string
-		items.Scan(&id, &name, &url)
+	for _, item := range items {
 		itemList = append(itemList, ItemListItem{
-			ID:   id,
-			Name: name,
-			URL:  "assets/" + url,
+			ID:           item.ID,
+			Name:         item.Name,
+			URL:          "assets/" + item.URL,
+			ThumbnailURL: thumbnailPath(item.Thumbnail),
 		})
 	}
 	
@@ -417,10 +478,14 @@ func listFolderContentsJSON(w http.ResponseWriter, r *http.Request) {
 	}
 	
 	response := FolderContentsResponse{
-		Success: true,
-		Folders: folders,
-		Items:   itemList,
-		Parent:  parentInfo,
+		Success:           true,
+		Folders:           folders,
+		Items:             itemList,
+		Parent:            parentInfo,
+		FoldersTotal:      foldersTotal,
+		FoldersNextCursor: foldersNextCursor,
+		ItemsTotal:        itemsTotal,
+		ItemsNextCursor:   itemsNextCursor,
 	}
 	
 	json.NewEncoder(w).Encode(response)
@@ -503,6 +568,85 @@ func getInventoryRootFolder(w http.ResponseWriter, r *http.Request) {
     json.NewEncoder(w).Encode(response)
 }
 
+// getUsageJSON handles GET /api/usage
+func getUsageJSON(w http.ResponseWriter, r *http.Request) {
+	claims, err := authentication.ParseToken(authentication.RequestToken(r))
+	if err != nil {
+		http.Error(w, "Auth token invalid or missing", http.StatusUnauthorized)
+		return
+	}
+
+	// Set JSON content type
+	w.Header().Set("Content-Type", "application/json")
+
+	userUsage, inventories, err := getUsage(claims.UID)
+	if err != nil {
+		json.NewEncoder(w).Encode(UsageResponse{Success: false})
+		return
+	}
+
+	response := UsageResponse{
+		Success:     true,
+		User:        UsageInfo{UsedBytes: userUsage.UsedBytes, QuotaBytes: userUsage.QuotaBytes},
+		Inventories: inventories,
+	}
+
+	json.NewEncoder(w).Encode(response)
+}
+
+// getItemAssetsJSON handles GET /api/items/assets
+func getItemAssetsJSON(w http.ResponseWriter, r *http.Request) {
+	itemId, err := strconv.Atoi(r.URL.Query().Get("itemId"))
+	if err != nil {
+		http.Error(w, "itemId is either not specified or is invalid", http.StatusBadRequest)
+		return
+	}
+
+	claims, err := authentication.ParseToken(authentication.RequestToken(r))
+	if err != nil {
+		http.Error(w, "Auth token invalid or missing", http.StatusUnauthorized)
+		return
+	}
+
+	var folderId int
+	if err := database.Db.QueryRow("SELECT folder_id FROM Items WHERE id = ?", itemId).Scan(&folderId); err != nil {
+		http.Error(w, "Item not found", http.StatusNotFound)
+		return
+	}
+	if allowed, err := CheckFolderAccess(folderId, claims.UID, "viewer"); err != nil || !allowed {
+		http.Error(w, "You don't have access to this item", http.StatusForbidden)
+		return
+	}
+
+	// Set JSON content type
+	w.Header().Set("Content-Type", "application/json")
+
+	rows, err := database.Db.Query(`
+		SELECT a.hash, a.size, COALESCE(a.content_type, ''), COALESCE(a.kind, '')
+		FROM Assets a
+		INNER JOIN `+"`hash-usage`"+` hu ON hu.asset_id = a.id
+		WHERE hu.item_id = ?
+		ORDER BY a.hash
+	`, itemId)
+	if err != nil {
+		json.NewEncoder(w).Encode(ItemAssetsResponse{Success: false})
+		return
+	}
+	defer rows.Close()
+
+	assets := []AssetListItem{}
+	for rows.Next() {
+		var asset AssetListItem
+		if err := rows.Scan(&asset.Hash, &asset.Size, &asset.ContentType, &asset.Kind); err != nil {
+			json.NewEncoder(w).Encode(ItemAssetsResponse{Success: false})
+			return
+		}
+		assets = append(assets, asset)
+	}
+
+	json.NewEncoder(w).Encode(ItemAssetsResponse{Success: true, Data: assets})
+}
+
 // AddJSONAPIListeners registers the JSON API endpoints
 func AddJSONAPIListeners() {
 	http.HandleFunc("/api/inventories", listInventoriesJSON)
@@ -510,4 +654,10 @@ func AddJSONAPIListeners() {
 	http.HandleFunc("/api/folders/items", listItemsJSON)
 	http.HandleFunc("/api/folders/contents", listFolderContentsJSON)
 	http.HandleFunc("/api/inventory/rootFolder", getInventoryRootFolder)
+	http.HandleFunc("/api/usage", getUsageJSON)
+	http.HandleFunc("/api/items/assets", getItemAssetsJSON)
+	http.HandleFunc("/api/assets/items", getAssetItemsJSON)
+	http.HandleFunc("/api/folders/assets", getSubtreeAssetsJSON)
+	http.HandleFunc("/api/search", searchJSON)
+	http.HandleFunc("/api/tags", listTagsJSON)
 }
@@ -0,0 +1,260 @@
+package query
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"resonite-file-provider/database"
+	"strconv"
+	"strings"
+)
+
+// maxPageLimit is the largest page a listing can be asked for
+const maxPageLimit = 1000
+
+// Sort orders understood by the listing endpoints. Folders have no size, so
+// sortSize lists them by name.
+const (
+	sortName    = "name"
+	sortCreated = "created"
+	sortSize    = "size"
+)
+
+// listOptions describe which page of a listing to return and in which order.
+// A zero limit returns every remaining entry. Items are only listed if they
+// have the tag, when one is given; folders aren't tagged and ignore it.
+type listOptions struct {
+	sort  string
+	desc  bool
+	limit int
+	after *listCursor
+	tag   string
+}
+
+// listCursor marks the last entry of a page. The listing continues after
+// its sort key and id, so entries added or removed meanwhile don't shift the
+// following pages. Only one of Name and Value is used, depending on the
+// sort column.
+type listCursor struct {
+	Sort  string `json:"s"`
+	Desc  bool   `json:"d,omitempty"`
+	Name  string `json:"n,omitempty"`
+	Value int64  `json:"v,omitempty"`
+	ID    int    `json:"i"`
+}
+
+// encode returns the cursor in the opaque form handed to clients
+func (c listCursor) encode() string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeListCursor(cursor string) (*listCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, err
+	}
+	var c listCursor
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// parseListOptions reads the sort, order, limit and tag parameters and the
+// cursor in cursorParam, which differs for listings sharing a request
+func parseListOptions(q url.Values, cursorParam string) (listOptions, error) {
+	opts := listOptions{sort: sortName, tag: strings.TrimSpace(q.Get("tag"))}
+	if sort := q.Get("sort"); sort != "" {
+		if sort != sortName && sort != sortCreated && sort != sortSize {
+			return opts, errors.New("sort must be name, created or size")
+		}
+		opts.sort = sort
+	}
+
+	switch q.Get("order") {
+	case "", "asc":
+	case "desc":
+		opts.desc = true
+	default:
+		return opts, errors.New("order must be asc or desc")
+	}
+
+	if limit := q.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 1 || n > maxPageLimit {
+			return opts, fmt.Errorf("limit must be between 1 and %d", maxPageLimit)
+		}
+		opts.limit = n
+	}
+
+	if cursor := q.Get(cursorParam); cursor != "" {
+		after, err := decodeListCursor(cursor)
+		if err != nil {
+			return opts, errors.New(cursorParam + " is invalid")
+		}
+		if after.Sort != opts.sort || after.Desc != opts.desc {
+			return opts, errors.New(cursorParam + " belongs to a different sort order")
+		}
+		opts.after = after
+	}
+	return opts, nil
+}
+
+// pageQuery wraps a query in the ordering, cursor condition and limit of the
+// page. The query must select an id column and the sort column. One row more
+// than the page holds is fetched to tell whether another page follows.
+func (o listOptions) pageQuery(query string, args []interface{}, column string) (string, []interface{}) {
+	direction, compare := "ASC", ">"
+	if o.desc {
+		direction, compare = "DESC", "<"
+	}
+
+	paged := "SELECT * FROM (" + query + ") page"
+	if o.after != nil {
+		var key interface{} = o.after.Value
+		if column == sortName {
+			key = o.after.Name
+		}
+		paged += fmt.Sprintf(" WHERE (page.%[1]s %[2]s ? OR (page.%[1]s = ? AND page.id %[2]s ?))", column, compare)
+		args = append(args, key, key, o.after.ID)
+	}
+	paged += fmt.Sprintf(" ORDER BY page.%s %s, page.id %s", column, direction, direction)
+	if o.limit > 0 {
+		paged += " LIMIT ?"
+		args = append(args, o.limit+1)
+	}
+	return paged, args
+}
+
+// cursorAfter returns the cursor continuing after the given entry of a page
+// ordered by column, the same column handed to pageQuery
+func (o listOptions) cursorAfter(column string, id int, name string, created int64, size int64) string {
+	c := listCursor{Sort: o.sort, Desc: o.desc, ID: id}
+	switch column {
+	case sortCreated:
+		c.Value = created
+	case sortSize:
+		c.Value = size
+	default:
+		c.Name = name
+	}
+	return c.encode()
+}
+
+// folderRow is a folder as read for a listing
+type folderRow struct {
+	ID      int
+	Name    string
+	Created int64
+}
+
+// itemRow is an item as read for a listing. Size totals the assets the item
+// uses.
+type itemRow struct {
+	ID        int
+	Name      string
+	URL       string
+	Thumbnail string
+	Created   int64
+	Size      int64
+}
+
+// listChildFolders returns a page of the folders in a folder, the number of
+// folders across all pages and the cursor of the next page, or "" if this is
+// the last one
+func listChildFolders(folderId int, opts listOptions) ([]folderRow, int, string, error) {
+	var total int
+	if err := database.Db.QueryRow("SELECT COUNT(*) FROM Folders WHERE parent_folder_id = ?", folderId).Scan(&total); err != nil {
+		return nil, 0, "", err
+	}
+
+	column := opts.sort
+	if column == sortSize {
+		column = sortName
+	}
+	query, args := opts.pageQuery(
+		"SELECT id, name, UNIX_TIMESTAMP(created_at) AS created FROM Folders WHERE parent_folder_id = ?",
+		[]interface{}{folderId}, column)
+	rows, err := database.Db.Query(query, args...)
+	if err != nil {
+		return nil, 0, "", err
+	}
+	defer rows.Close()
+
+	var folders []folderRow
+	for rows.Next() {
+		var folder folderRow
+		if err := rows.Scan(&folder.ID, &folder.Name, &folder.Created); err != nil {
+			return nil, 0, "", err
+		}
+		folders = append(folders, folder)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, "", err
+	}
+
+	var next string
+	if opts.limit > 0 && len(folders) > opts.limit {
+		folders = folders[:opts.limit]
+		last := folders[len(folders)-1]
+		next = opts.cursorAfter(column, last.ID, last.Name, last.Created, 0)
+	}
+	return folders, total, next, nil
+}
+
+// listChildItems returns a page of the items in a folder, the number of
+// items across all pages and the cursor of the next page, or "" if this is
+// the last one
+func listChildItems(folderId int, opts listOptions) ([]itemRow, int, string, error) {
+	condition := ""
+	args := []interface{}{folderId}
+	if opts.tag != "" {
+		condition = " AND i.id IN (SELECT itg.item_id FROM item_tags itg INNER JOIN Tags t ON t.id = itg.tag_id WHERE t.name = ?)"
+		args = append(args, opts.tag)
+	}
+
+	var total int
+	if err := database.Db.QueryRow("SELECT COUNT(*) FROM Items i WHERE i.folder_id = ?"+condition, args...).Scan(&total); err != nil {
+		return nil, 0, "", err
+	}
+
+	column := opts.sort
+	query, args := opts.pageQuery(`
+		SELECT i.id, i.name, i.url, COALESCE(i.thumbnail_url, '') AS thumbnail,
+		       UNIX_TIMESTAMP(i.created_at) AS created,
+		       CAST(COALESCE((
+		           SELECT SUM(a.size) FROM Assets a
+		           WHERE a.id IN (SELECT hu.asset_id FROM `+"`hash-usage`"+` hu WHERE hu.item_id = i.id)
+		       ), 0) AS SIGNED) AS size
+		FROM Items i
+		WHERE i.folder_id = ?`+condition,
+		args, column)
+	rows, err := database.Db.Query(query, args...)
+	if err != nil {
+		return nil, 0, "", err
+	}
+	defer rows.Close()
+
+	var items []itemRow
+	for rows.Next() {
+		var item itemRow
+		if err := rows.Scan(&item.ID, &item.Name, &item.URL, &item.Thumbnail, &item.Created, &item.Size); err != nil {
+			return nil, 0, "", err
+		}
+		items = append(items, item)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, "", err
+	}
+
+	var next string
+	if opts.limit > 0 && len(items) > opts.limit {
+		items = items[:opts.limit]
+		last := items[len(items)-1]
+		next = opts.cursorAfter(column, last.ID, last.Name, last.Created, last.Size)
+	}
+	return items, total, next, nil
+}
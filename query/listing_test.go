@@ -0,0 +1,109 @@
+package query
+
+import (
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseListOptions(t *testing.T) {
+	opts, err := parseListOptions(url.Values{}, "cursor")
+	if err != nil {
+		t.Fatalf("parseListOptions: %v", err)
+	}
+	if want := (listOptions{sort: sortName}); !reflect.DeepEqual(opts, want) {
+		t.Errorf("defaults = %+v, want %+v", opts, want)
+	}
+
+	for _, q := range []url.Values{
+		{"sort": {"color"}},
+		{"order": {"up"}},
+		{"limit": {"0"}},
+		{"limit": {"1001"}},
+		{"limit": {"ten"}},
+		{"cursor": {"not a cursor"}},
+	} {
+		if _, err := parseListOptions(q, "cursor"); err == nil {
+			t.Errorf("parseListOptions(%v) succeeded", q)
+		}
+	}
+}
+
+// TestCursorRoundTrip pages through every sort order the way the listings do:
+// the cursor made from the last entry of a page is parsed back from the next
+// request and must continue after that entry
+func TestCursorRoundTrip(t *testing.T) {
+	tests := []struct {
+		sort   string
+		order  string
+		column string
+		key    interface{}
+	}{
+		{sortName, "asc", sortName, "beta"},
+		{sortName, "desc", sortName, "beta"},
+		{sortCreated, "asc", sortCreated, int64(1700000000)},
+		{sortCreated, "desc", sortCreated, int64(1700000000)},
+		{sortSize, "asc", sortSize, int64(4096)},
+		{sortSize, "desc", sortSize, int64(4096)},
+		// Folders have no size and are listed by name instead
+		{sortSize, "asc", sortName, "beta"},
+		{sortSize, "desc", sortName, "beta"},
+	}
+	for _, tt := range tests {
+		q := url.Values{"sort": {tt.sort}, "order": {tt.order}, "limit": {"2"}}
+		opts, err := parseListOptions(q, "cursor")
+		if err != nil {
+			t.Fatalf("parseListOptions(%v): %v", q, err)
+		}
+		q.Set("cursor", opts.cursorAfter(tt.column, 42, "beta", 1700000000, 4096))
+
+		next, err := parseListOptions(q, "cursor")
+		if err != nil {
+			t.Fatalf("%s %s by %s: parseListOptions: %v", tt.sort, tt.order, tt.column, err)
+		}
+		query, args := next.pageQuery("SELECT id, name FROM Folders WHERE parent_folder_id = ?", []interface{}{7}, tt.column)
+
+		compare, direction := ">", "ASC"
+		if tt.order == "desc" {
+			compare, direction = "<", "DESC"
+		}
+		wantWhere := "WHERE (page." + tt.column + " " + compare + " ? OR (page." + tt.column + " = ? AND page.id " + compare + " ?))"
+		if !strings.Contains(query, wantWhere) {
+			t.Errorf("%s %s by %s: query %q lacks %q", tt.sort, tt.order, tt.column, query, wantWhere)
+		}
+		wantOrder := "ORDER BY page." + tt.column + " " + direction + ", page.id " + direction + " LIMIT ?"
+		if !strings.HasSuffix(query, wantOrder) {
+			t.Errorf("%s %s by %s: query %q doesn't end in %q", tt.sort, tt.order, tt.column, query, wantOrder)
+		}
+		if want := []interface{}{7, tt.key, tt.key, 42, 3}; !reflect.DeepEqual(args, want) {
+			t.Errorf("%s %s by %s: args = %v, want %v", tt.sort, tt.order, tt.column, args, want)
+		}
+	}
+}
+
+func TestCursorRejectsOtherOrder(t *testing.T) {
+	opts, err := parseListOptions(url.Values{"sort": {sortName}}, "cursor")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cursor := opts.cursorAfter(sortName, 1, "a", 0, 0)
+	for _, q := range []url.Values{
+		{"sort": {sortCreated}, "cursor": {cursor}},
+		{"sort": {sortName}, "order": {"desc"}, "cursor": {cursor}},
+	} {
+		if _, err := parseListOptions(q, "cursor"); err == nil {
+			t.Errorf("parseListOptions(%v) accepted a cursor of another order", q)
+		}
+	}
+}
+
+func TestPageQueryWithoutCursor(t *testing.T) {
+	query, args := listOptions{sort: sortName}.pageQuery("SELECT id, name FROM Items", nil, sortName)
+	if want := "SELECT * FROM (SELECT id, name FROM Items) page ORDER BY page.name ASC, page.id ASC"; query != want {
+		t.Errorf("pageQuery = %q, want %q", query, want)
+	}
+	if len(args) != 0 {
+		t.Errorf("pageQuery args = %v, want none", args)
+	}
+}
@@ -2,39 +2,78 @@ package query
 
 import (
 	"database/sql"
+	"fmt"
 	"net/http"
 	"path/filepath"
 	"resonite-file-provider/animxmaker"
 	"resonite-file-provider/authentication"
 	"resonite-file-provider/database"
+	"resonite-file-provider/quota"
 	"strconv"
 )
 
-func getChildFoldersTracks(folderId int, nodeName string) (animxmaker.AnimationTrackWrapper, animxmaker.AnimationTrackWrapper, animxmaker.AnimationTrackWrapper, error) {
-	childFolders, err := database.Db.Query("SELECT id, name FROM Folders where parent_folder_id = ?", folderId)
+// Error codes sent in the status node of failed AnimX responses
+const (
+	errorInvalidParameter = "invalid_parameter"
+	errorUnauthorized     = "unauthorized"
+	errorForbidden        = "forbidden"
+	errorInternal         = "internal_error"
+)
+
+// writeAnimation sends tracks as a successful AnimX response
+func writeAnimation(w http.ResponseWriter, tracks []animxmaker.AnimationTrackWrapper) {
+	writeAnimationTracks(w, append(tracks, animxmaker.StatusTracks(http.StatusOK, "ok", "")...))
+}
+
+// writeAnimationError sends a failure as an AnimX response holding only the
+// status node. In-world clients only get to read the body of a successful
+// import, so the HTTP status stays 200 and the real one is in the status track.
+func writeAnimationError(w http.ResponseWriter, status int, code string, message string) {
+	writeAnimationTracks(w, animxmaker.StatusTracks(status, code, message))
+}
+
+// writeAnimationTracks streams an AnimX response. The response is under way
+// once encoding starts, so errors can only be logged.
+func writeAnimationTracks(w http.ResponseWriter, tracks []animxmaker.AnimationTrackWrapper) {
+	response := animxmaker.Animation{Tracks: tracks}
+	if err := animxmaker.NewEncoder(w).Encode(&response, "response"); err != nil {
+		fmt.Println("[QUERY] Failed to encode animx response:", err)
+	}
+}
+
+// animxFolder is a folder as listed in AnimX responses
+type animxFolder struct {
+	ID   int32  `animx:"id"`
+	Name string `animx:"name"`
+}
+
+// animxItem is an item as listed in AnimX responses. Thumbnails are sent
+// separately, see getChildItemsTracks.
+type animxItem struct {
+	ID   int32  `animx:"id"`
+	Name string `animx:"name"`
+	URL  string `animx:"url"`
+}
+
+func getChildFoldersTracks(folderId int, nodeName string, opts listOptions) ([]animxmaker.AnimationTrackWrapper, error) {
+	childFolders, total, nextCursor, err := listChildFolders(folderId, opts)
 	if err != nil {
-		return nil, nil, nil, err
+		return nil, err
 	}
 	var parentFolderId sql.NullInt64
 	if err := database.Db.QueryRow("SELECT parent_folder_id FROM Folders WHERE id = ?", folderId).Scan(&parentFolderId); err != nil {
-		return nil, nil, nil, err
+		return nil, err
 	}
-	var childFoldersIds []int32
-	var childFoldersNames []string
-	defer childFolders.Close()
-	
-	for childFolders.Next() {
-		var id int32
-		var name string
-		if err := childFolders.Scan(&id, &name); err != nil {
-			return nil, nil, nil, err
-		}
-		childFoldersIds = append(childFoldersIds, id)
-		childFoldersNames = append(childFoldersNames, name)
+	var folders []animxFolder
+	for _, childFolder := range childFolders {
+		folders = append(folders, animxFolder{ID: int32(childFolder.ID), Name: childFolder.Name})
 	}
 
-	idsTrack := animxmaker.ListTrack(childFoldersIds, nodeName, "id")
-	namesTrack := animxmaker.ListTrack(childFoldersNames, nodeName, "name")
+	tracks, err := animxmaker.Marshal(folders, nodeName)
+	if err != nil {
+		return nil, err
+	}
+	countTrack := animxmaker.CountTrack(len(folders), nodeName)
 	
 	// Handle NULL parent folder ID (which indicates root folder)
 	var parentFolderIdValue int32
@@ -45,35 +84,46 @@ func getChildFoldersTracks(folderId int, nodeName string) (animxmaker.AnimationT
 	}
 	
 	parentFolderTrack := animxmaker.ListTrack([]int32{parentFolderIdValue}, nodeName, "parentFolder")
-	return &idsTrack, &namesTrack, &parentFolderTrack, nil
+	tracks = append(tracks, &parentFolderTrack, &countTrack)
+	return append(tracks, animxmaker.PageTracks(total, nextCursor, nodeName)...), nil
 }
 
-func getChildItemsTracks(folderId int, nodeName string) (animxmaker.AnimationTrackWrapper, animxmaker.AnimationTrackWrapper, animxmaker.AnimationTrackWrapper, error) {
-	items, err := database.Db.Query("SELECT id, name, url FROM Items where folder_id = ?", folderId)
-	if err != nil {
-		return nil, nil, nil, err
+// thumbnailPath returns the asset path of a thumbnail hash, or "" if the
+// item has no thumbnail
+func thumbnailPath(hash string) string {
+	if hash == "" {
+		return ""
 	}
+	return filepath.Join("assets", hash)
+}
 
-	var itemsIds []int32
-	var itemsNames []string
-	var itemsUrls []string
-	defer items.Close()
+// getChildItemsTracks returns the tracks listing the items of a folder in two
+// parts: the item tracks, and the thumbnail, count and page tracks following
+// them. folderContent sends its folder tracks in between.
+func getChildItemsTracks(folderId int, nodeName string, opts listOptions) ([]animxmaker.AnimationTrackWrapper, []animxmaker.AnimationTrackWrapper, error) {
+	items, total, nextCursor, err := listChildItems(folderId, opts)
+	if err != nil {
+		return nil, nil, err
+	}
 
-	for items.Next() {
-		var id int32
-		var name string
-		var url string
-		if err := items.Scan(&id, &name, &url); err != nil {
-			return nil, nil, nil, err
-		}
-		itemsIds = append(itemsIds, id)
-		itemsNames = append(itemsNames, name)
-		itemsUrls = append(itemsUrls, filepath.Join("assets", url))
-	}
-	idsTrack := animxmaker.ListTrack(itemsIds, nodeName, "id")
-	namesTrack := animxmaker.ListTrack(itemsNames, nodeName, "name")
-	urlsTrack := animxmaker.ListTrack(itemsUrls, nodeName, "url")
-	return &idsTrack, &namesTrack, &urlsTrack, nil
+	var itemList []animxItem
+	var thumbnails []string
+	for _, item := range items {
+		itemList = append(itemList, animxItem{
+			ID:   int32(item.ID),
+			Name: item.Name,
+			URL:  filepath.Join("assets", item.URL),
+		})
+		thumbnails = append(thumbnails, thumbnailPath(item.Thumbnail))
+	}
+	tracks, err := animxmaker.Marshal(itemList, nodeName)
+	if err != nil {
+		return nil, nil, err
+	}
+	thumbnailTrack := animxmaker.ListTrack(thumbnails, nodeName, "thumbnailUrl")
+	countTrack := animxmaker.CountTrack(len(itemList), nodeName)
+	trailing := []animxmaker.AnimationTrackWrapper{&thumbnailTrack, &countTrack}
+	return tracks, append(trailing, animxmaker.PageTracks(total, nextCursor, nodeName)...), nil
 }
 
 
@@ -94,82 +144,114 @@ func IsFolderOwner(folderId int, userId int) (bool, error) {
 	return false, nil
 }
 
+// accessLevels ranks the values of users_inventories.access_level so that a
+// higher level implies every lower one
+var accessLevels = map[string]int{
+	"viewer": 1,
+	"editor": 2,
+	"owner":  3,
+}
+
+// CheckFolderAccess reports whether the user holds at least the given access
+// level ("viewer", "editor" or "owner") on the inventory containing the folder
+func CheckFolderAccess(folderId int, userId int, level string) (bool, error) {
+	required, ok := accessLevels[level]
+	if !ok {
+		return false, fmt.Errorf("unknown access level %q", level)
+	}
+	rows, err := database.Db.Query(`
+		SELECT ui.access_level
+		FROM users_inventories ui
+		INNER JOIN Folders f ON f.inventory_id = ui.inventory_id
+		WHERE f.id = ? AND ui.user_id = ?
+	`, folderId, userId)
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var accessLevel string
+		if err := rows.Scan(&accessLevel); err != nil {
+			return false, err
+		}
+		if accessLevels[accessLevel] >= required {
+			return true, nil
+		}
+	}
+	return false, rows.Err()
+}
+
 func listFolders(w http.ResponseWriter, r *http.Request) {
 	folderId, err := strconv.Atoi(r.URL.Query().Get("folderId"))
 	if err != nil {
-		http.Error(w, "folderId is either not specified or is invalid", http.StatusBadRequest)
+		writeAnimationError(w, http.StatusBadRequest, errorInvalidParameter, "folderId is either not specified or is invalid")
 		return
 	}
 	authKey := r.URL.Query().Get("auth")
 	claims, err := authentication.ParseToken(authKey)
 	if err != nil {
-		http.Error(w, "Auth token invalid or missing", http.StatusUnauthorized)
+		writeAnimationError(w, http.StatusUnauthorized, errorUnauthorized, "Auth token invalid or missing")
 		return
 	}
 	
 	// Check if user has at least viewer access
 	if allowed, err := CheckFolderAccess(folderId, claims.UID, "viewer"); !allowed || err != nil {
-		http.Error(w, "You don't have access to this folder", http.StatusForbidden)
+		writeAnimationError(w, http.StatusForbidden, errorForbidden, "You don't have access to this folder")
 		return
 	}
 	
-	idsTrack, namesTrack, parentFoldertrack, err := getChildFoldersTracks(folderId, "results")
-	response := animxmaker.Animation{
-		Tracks: []animxmaker.AnimationTrackWrapper{
-			idsTrack,
-			namesTrack,
-			parentFoldertrack,
-		},
-	}
-	encodedResponse, err := response.EncodeAnimation("response")
+	opts, err := parseListOptions(r.URL.Query(), "cursor")
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeAnimationError(w, http.StatusBadRequest, errorInvalidParameter, err.Error())
 		return
 	}
-	w.WriteHeader(http.StatusOK)
-	w.Write(encodedResponse)
+	
+	tracks, err := getChildFoldersTracks(folderId, "results", opts)
+	if err != nil {
+		writeAnimationError(w, http.StatusInternalServerError, errorInternal, "Error while getting folders")
+		return
+	}
+	writeAnimation(w, tracks)
 }
 
 func listItems(w http.ResponseWriter, r *http.Request) {
 	folderId, err := strconv.Atoi(r.URL.Query().Get("folderId"))
 	if err != nil {
-		http.Error(w, "folderId is either not specified or is invalid", http.StatusBadRequest)
+		writeAnimationError(w, http.StatusBadRequest, errorInvalidParameter, "folderId is either not specified or is invalid")
+		return
 	}
 	authKey := r.URL.Query().Get("auth")
 	claims, err := authentication.ParseToken(authKey)
 	if err != nil {
-		http.Error(w, "Auth token invalid or missing", http.StatusUnauthorized)
+		writeAnimationError(w, http.StatusUnauthorized, errorUnauthorized, "Auth token invalid or missing")
 		return
 	}
 	
 	// Check if user has at least viewer access
 	if allowed, err := CheckFolderAccess(folderId, claims.UID, "viewer"); !allowed || err != nil {
-		http.Error(w, "You don't have access to this folder", http.StatusForbidden)
+		writeAnimationError(w, http.StatusForbidden, errorForbidden, "You don't have access to this folder")
 		return
 	}
 	
-	idsTrack, namesTrack, urlsTrack, err := getChildItemsTracks(folderId, "results")
-	response := animxmaker.Animation{
-		Tracks: []animxmaker.AnimationTrackWrapper{
-			idsTrack,
-			namesTrack,
-			urlsTrack,
-		},
-	}
-	encodedResponse, err := response.EncodeAnimation("response")
+	opts, err := parseListOptions(r.URL.Query(), "cursor")
 	if err != nil {
-		http.Error(w, "Error while encoding animx", http.StatusInternalServerError)
+		writeAnimationError(w, http.StatusBadRequest, errorInvalidParameter, err.Error())
 		return
 	}
-	w.WriteHeader(http.StatusOK)
-	w.Write(encodedResponse)
+	
+	itemTracks, trailingTracks, err := getChildItemsTracks(folderId, "results", opts)
+	if err != nil {
+		writeAnimationError(w, http.StatusInternalServerError, errorInternal, "Error while getting items")
+		return
+	}
+	writeAnimation(w, append(itemTracks, trailingTracks...))
 }
 
 func listInventories(w http.ResponseWriter, r *http.Request){
 	auth := r.URL.Query().Get("auth")
 	claims, err := authentication.ParseToken(auth)
 	if err != nil {
-		http.Error(w, "Auth token invalid or missing", http.StatusUnauthorized)
+		writeAnimationError(w, http.StatusUnauthorized, errorUnauthorized, "Auth token invalid or missing")
 		return
 	}
 	
@@ -182,10 +264,12 @@ func listInventories(w http.ResponseWriter, r *http.Request){
 	`, claims.UID)
 	
 	if err != nil {
-		http.Error(w, "Failed to query the database", http.StatusInternalServerError)
+		writeAnimationError(w, http.StatusInternalServerError, errorInternal, "Failed to query the database")
 		return
 	}
 	
+	defer result.Close()
+
 	var inventoryIds []int
 	var inventoryNames []string
 	for result.Next() {
@@ -197,63 +281,147 @@ func listInventories(w http.ResponseWriter, r *http.Request){
 	}
 	idsTrack := animxmaker.ListTrack(inventoryIds, "results", "id")
 	namesTrack := animxmaker.ListTrack(inventoryNames, "results", "name")
-	response := animxmaker.Animation{
-		Tracks: []animxmaker.AnimationTrackWrapper{
-			animxmaker.AnimationTrackWrapper(&idsTrack),
-			animxmaker.AnimationTrackWrapper(&namesTrack),
-		},
-	}
-	encodedResponse, err := response.EncodeAnimation("response")
-	if err != nil {
-		http.Error(w, "Error while encoding animx", http.StatusInternalServerError)
-	}
-	w.Write(encodedResponse)
-	w.WriteHeader(http.StatusOK)
+	countTrack := animxmaker.CountTrack(len(inventoryIds), "results")
+	writeAnimation(w, []animxmaker.AnimationTrackWrapper{
+		&idsTrack,
+		&namesTrack,
+		&countTrack,
+	})
 }
 
 func listFolderContents(w http.ResponseWriter, r *http.Request) {
 	folderId, err := strconv.Atoi(r.URL.Query().Get("folderId"))
 	if err != nil {
-		http.Error(w, "folderId is either not specified or is invalid", http.StatusBadRequest)
+		writeAnimationError(w, http.StatusBadRequest, errorInvalidParameter, "folderId is either not specified or is invalid")
+		return
 	}
 	authKey := r.URL.Query().Get("auth")
 	claims, err := authentication.ParseToken(authKey)
 	if err != nil {
-		http.Error(w, "Auth token invalid or missing", http.StatusUnauthorized)
+		writeAnimationError(w, http.StatusUnauthorized, errorUnauthorized, "Auth token invalid or missing")
 		return
 	}
 	
 	// Check if user has at least viewer access
 	if allowed, err := CheckFolderAccess(folderId, claims.UID, "viewer"); !allowed || err != nil {
-		http.Error(w, "You don't have access to this folder", http.StatusForbidden)
+		writeAnimationError(w, http.StatusForbidden, errorForbidden, "You don't have access to this folder")
 		return
 	}
 	
-	itemIdsTrack, itemNamesTrack, itemUrlsTrack, err := getChildItemsTracks(folderId, "items")
+	itemOpts, err := parseListOptions(r.URL.Query(), "itemCursor")
 	if err != nil {
-		http.Error(w, "Error while getting items", http.StatusInternalServerError)
+		writeAnimationError(w, http.StatusBadRequest, errorInvalidParameter, err.Error())
 		return
 	}
-	folderIdsTrack, folderNamesTrack, parentFolderTrack, err := getChildFoldersTracks(folderId, "folders")
+	folderOpts, err := parseListOptions(r.URL.Query(), "folderCursor")
 	if err != nil {
-		http.Error(w, "Error while getting folders", http.StatusInternalServerError)
+		writeAnimationError(w, http.StatusBadRequest, errorInvalidParameter, err.Error())
 		return
 	}
-	response := animxmaker.Animation{
-		Tracks: []animxmaker.AnimationTrackWrapper{
-			itemIdsTrack,
-			itemNamesTrack,
-			itemUrlsTrack,
-			folderIdsTrack,
-			folderNamesTrack,
-			parentFolderTrack,
-		},
-	}
-	encodedResponse, err := response.EncodeAnimation("response")
+	
+	itemTracks, trailingItemTracks, err := getChildItemsTracks(folderId, "items", itemOpts)
 	if err != nil {
-		http.Error(w, "Error while encoding animx", http.StatusInternalServerError)
+		writeAnimationError(w, http.StatusInternalServerError, errorInternal, "Error while getting items")
+		return
+	}
+	folderTracks, err := getChildFoldersTracks(folderId, "folders", folderOpts)
+	if err != nil {
+		writeAnimationError(w, http.StatusInternalServerError, errorInternal, "Error while getting folders")
+		return
+	}
+	// Thumbnails came after the folder tracks, keep them there for existing clients
+	var tracks []animxmaker.AnimationTrackWrapper
+	tracks = append(tracks, itemTracks...)
+	tracks = append(tracks, folderTracks...)
+	tracks = append(tracks, trailingItemTracks...)
+	writeAnimation(w, tracks)
+}
+
+// getUsage returns the storage used by a user and by each inventory they can
+// access
+func getUsage(userId int) (quota.Usage, []InventoryUsageItem, error) {
+	userUsage, err := quota.UserUsage(userId)
+	if err != nil {
+		return quota.Usage{}, nil, err
+	}
+	result, err := database.Db.Query(`
+		SELECT i.id, i.name
+		FROM Inventories i
+		INNER JOIN users_inventories ui ON i.id = ui.inventory_id
+		WHERE ui.user_id = ?
+	`, userId)
+	if err != nil {
+		return quota.Usage{}, nil, err
+	}
+	var inventories []InventoryUsageItem
+	for result.Next() {
+		var inventory InventoryUsageItem
+		if err := result.Scan(&inventory.ID, &inventory.Name); err != nil {
+			result.Close()
+			return quota.Usage{}, nil, err
+		}
+		inventories = append(inventories, inventory)
+	}
+	result.Close()
+	for i := range inventories {
+		usage, err := quota.InventoryUsage(inventories[i].ID)
+		if err != nil {
+			return quota.Usage{}, nil, err
+		}
+		inventories[i].UsedBytes = usage.UsedBytes
+		inventories[i].QuotaBytes = usage.QuotaBytes
+	}
+	return userUsage, inventories, nil
+}
+
+// megabytes converts a byte count for in-world display, where -1 marks an
+// unlimited quota
+func megabytes(bytes int64, isQuota bool) float32 {
+	if isQuota && bytes == 0 {
+		return -1
+	}
+	return float32(float64(bytes) / (1024 * 1024))
+}
+
+func listUsage(w http.ResponseWriter, r *http.Request) {
+	auth := r.URL.Query().Get("auth")
+	claims, err := authentication.ParseToken(auth)
+	if err != nil {
+		writeAnimationError(w, http.StatusUnauthorized, errorUnauthorized, "Auth token invalid or missing")
+		return
+	}
+
+	userUsage, inventories, err := getUsage(claims.UID)
+	if err != nil {
+		writeAnimationError(w, http.StatusInternalServerError, errorInternal, "Failed to query the database")
+		return
+	}
+	var inventoryIds []int32
+	var inventoryNames []string
+	var inventoryUsed []float32
+	var inventoryQuotas []float32
+	for _, inventory := range inventories {
+		inventoryIds = append(inventoryIds, int32(inventory.ID))
+		inventoryNames = append(inventoryNames, inventory.Name)
+		inventoryUsed = append(inventoryUsed, megabytes(inventory.UsedBytes, false))
+		inventoryQuotas = append(inventoryQuotas, megabytes(inventory.QuotaBytes, true))
 	}
-	w.Write(encodedResponse)
+	userUsedTrack := animxmaker.ListTrack([]float32{megabytes(userUsage.UsedBytes, false)}, "user", "usedMB")
+	userQuotaTrack := animxmaker.ListTrack([]float32{megabytes(userUsage.QuotaBytes, true)}, "user", "quotaMB")
+	idsTrack := animxmaker.ListTrack(inventoryIds, "inventories", "id")
+	namesTrack := animxmaker.ListTrack(inventoryNames, "inventories", "name")
+	usedTrack := animxmaker.ListTrack(inventoryUsed, "inventories", "usedMB")
+	quotasTrack := animxmaker.ListTrack(inventoryQuotas, "inventories", "quotaMB")
+	countTrack := animxmaker.CountTrack(len(inventories), "inventories")
+	writeAnimation(w, []animxmaker.AnimationTrackWrapper{
+		&userUsedTrack,
+		&userQuotaTrack,
+		&idsTrack,
+		&namesTrack,
+		&usedTrack,
+		&quotasTrack,
+		&countTrack,
+	})
 }
 
 func AddSearchListeners() {
@@ -261,4 +429,7 @@ func AddSearchListeners() {
 	http.HandleFunc("/query/childItems", listItems)
 	http.HandleFunc("/query/folderContent", listFolderContents)
 	http.HandleFunc("/query/inventories", listInventories)
+	http.HandleFunc("/query/usage", listUsage)
+	http.HandleFunc("/query/search", searchAnimx)
+	http.HandleFunc("/query/tags", listTags)
 }
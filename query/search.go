@@ -0,0 +1,417 @@
+package query
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"resonite-file-provider/animxmaker"
+	"resonite-file-provider/assetinfo"
+	"resonite-file-provider/authentication"
+	"resonite-file-provider/database"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// defaultSearchLimit is how many results a search returns unless the caller
+// asks for another number
+const defaultSearchLimit = 50
+
+// Types of search result
+const (
+	resultItem   = "item"
+	resultFolder = "folder"
+)
+
+type SearchResponse struct {
+	Success bool           `json:"success"`
+	Data    []SearchResult `json:"data"`
+}
+
+// SearchResult is an item or folder found by a search. FolderID and Path
+// describe the folder it is in; root folders have FolderID -1 and an empty
+// Path. URL and ThumbnailURL are only set for items.
+type SearchResult struct {
+	Type          string `json:"type"`
+	ID            int    `json:"id"`
+	Name          string `json:"name"`
+	FolderID      int    `json:"folderId"`
+	Path          string `json:"path"`
+	InventoryID   int    `json:"inventoryId"`
+	InventoryName string `json:"inventoryName"`
+	URL           string `json:"url,omitempty"`
+	ThumbnailURL  string `json:"thumbnailUrl,omitempty"`
+}
+
+// animxSearchResult is a search result as listed in AnimX responses
+type animxSearchResult struct {
+	Type          string `animx:"type"`
+	ID            int32  `animx:"id"`
+	Name          string `animx:"name"`
+	FolderID      int32  `animx:"folderId"`
+	Path          string `animx:"path"`
+	InventoryID   int32  `animx:"inventoryId"`
+	InventoryName string `animx:"inventoryName"`
+	URL           string `animx:"url"`
+	ThumbnailURL  string `animx:"thumbnailUrl"`
+}
+
+// searchOptions describe what to search for. All given criteria have to
+// match. Folders have neither tags nor assets, so they are only searched by
+// name.
+type searchOptions struct {
+	text      string
+	substring bool
+	tags      []string
+	kind      string
+	items     bool
+	folders   bool
+	limit     int
+}
+
+// parseSearchOptions reads the q, match, tag, kind, type and limit parameters
+func parseSearchOptions(q url.Values) (searchOptions, error) {
+	opts := searchOptions{
+		text:    strings.TrimSpace(q.Get("q")),
+		tags:    q["tag"],
+		kind:    q.Get("kind"),
+		items:   true,
+		folders: true,
+		limit:   defaultSearchLimit,
+	}
+
+	switch q.Get("match") {
+	case "", "prefix":
+		if opts.text != "" && len(searchTerms(opts.text)) == 0 {
+			return opts, errors.New("q has no words to search for")
+		}
+	case "substring":
+		opts.substring = true
+	default:
+		return opts, errors.New("match must be prefix or substring")
+	}
+
+	switch opts.kind {
+	case "", assetinfo.KindTexture, assetinfo.KindMesh, assetinfo.KindAudio, assetinfo.KindRecord, assetinfo.KindOther:
+	default:
+		return opts, errors.New("kind must be texture, mesh, audio, record or other")
+	}
+
+	switch q.Get("type") {
+	case "":
+	case resultItem:
+		opts.folders = false
+	case resultFolder:
+		opts.items = false
+	default:
+		return opts, errors.New("type must be item or folder")
+	}
+	if len(opts.tags) > 0 || opts.kind != "" {
+		opts.folders = false
+	}
+	if !opts.items && (len(opts.tags) > 0 || opts.kind != "") {
+		return opts, errors.New("folders can't be searched by tag or kind")
+	}
+
+	if opts.text == "" && len(opts.tags) == 0 && opts.kind == "" {
+		return opts, errors.New("at least one of q, tag and kind is required")
+	}
+
+	if limit := q.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 1 || n > maxPageLimit {
+			return opts, fmt.Errorf("limit must be between 1 and %d", maxPageLimit)
+		}
+		opts.limit = n
+	}
+	return opts, nil
+}
+
+// searchTerms turns the words of a query into a boolean mode FULLTEXT search
+// requiring a word starting with each of them. Operator characters are
+// dropped so user input can't change the meaning of the search.
+func searchTerms(text string) []string {
+	var terms []string
+	for _, word := range strings.Fields(text) {
+		word = strings.Map(func(r rune) rune {
+			if strings.ContainsRune(`+-<>()~*"@`, r) {
+				return -1
+			}
+			return r
+		}, word)
+		if word != "" {
+			terms = append(terms, "+"+word+"*")
+		}
+	}
+	return terms
+}
+
+// nameCondition returns the condition matching column against the search
+// text, or "" if the search has no text
+func (o searchOptions) nameCondition(column string) (string, []interface{}) {
+	if o.text == "" {
+		return "", nil
+	}
+	if o.substring {
+		pattern := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(strings.ToLower(o.text))
+		return " AND LOWER(" + column + ") LIKE ?", []interface{}{"%" + pattern + "%"}
+	}
+	return " AND MATCH(" + column + ") AGAINST(? IN BOOLEAN MODE)", []interface{}{strings.Join(searchTerms(o.text), " ")}
+}
+
+// search finds the items and folders in the user's inventories matching the
+// options, ordered by name
+func search(userId int, opts searchOptions) ([]SearchResult, error) {
+	results := []SearchResult{}
+	if opts.folders {
+		folders, err := searchFolders(userId, opts)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, folders...)
+	}
+	if opts.items {
+		items, err := searchItems(userId, opts)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, items...)
+	}
+
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].Name < results[j].Name
+	})
+	if len(results) > opts.limit {
+		results = results[:opts.limit]
+	}
+
+	folderIds := make([]int, 0, len(results))
+	for _, result := range results {
+		if result.FolderID >= 0 {
+			folderIds = append(folderIds, result.FolderID)
+		}
+	}
+	paths, err := folderPaths(folderIds)
+	if err != nil {
+		return nil, err
+	}
+	for i := range results {
+		results[i].Path = paths[results[i].FolderID]
+	}
+	return results, nil
+}
+
+func searchFolders(userId int, opts searchOptions) ([]SearchResult, error) {
+	condition, args := opts.nameCondition("f.name")
+	rows, err := database.Db.Query(`
+		SELECT f.id, f.name, COALESCE(f.parent_folder_id, -1), f.inventory_id, inv.name
+		FROM Folders f
+		INNER JOIN Inventories inv ON inv.id = f.inventory_id
+		WHERE f.inventory_id IN (SELECT inventory_id FROM users_inventories WHERE user_id = ?)`+condition+`
+		ORDER BY f.name, f.id
+		LIMIT ?
+	`, append(append([]interface{}{userId}, args...), opts.limit)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var folders []SearchResult
+	for rows.Next() {
+		folder := SearchResult{Type: resultFolder}
+		if err := rows.Scan(&folder.ID, &folder.Name, &folder.FolderID, &folder.InventoryID, &folder.InventoryName); err != nil {
+			return nil, err
+		}
+		folders = append(folders, folder)
+	}
+	return folders, rows.Err()
+}
+
+func searchItems(userId int, opts searchOptions) ([]SearchResult, error) {
+	condition, args := opts.nameCondition("it.name")
+	for _, tag := range opts.tags {
+		condition += `
+		AND EXISTS (
+			SELECT 1 FROM item_tags itg
+			INNER JOIN Tags t ON t.id = itg.tag_id
+			WHERE itg.item_id = it.id AND t.name = ?
+		)`
+		args = append(args, tag)
+	}
+	if opts.kind != "" {
+		condition += `
+		AND EXISTS (
+			SELECT 1 FROM ` + "`hash-usage`" + ` hu
+			INNER JOIN Assets a ON a.id = hu.asset_id
+			WHERE hu.item_id = it.id AND a.kind = ?
+		)`
+		args = append(args, opts.kind)
+	}
+
+	rows, err := database.Db.Query(`
+		SELECT it.id, it.name, it.url, COALESCE(it.thumbnail_url, ''), it.folder_id, f.inventory_id, inv.name
+		FROM Items it
+		INNER JOIN Folders f ON f.id = it.folder_id
+		INNER JOIN Inventories inv ON inv.id = f.inventory_id
+		WHERE f.inventory_id IN (SELECT inventory_id FROM users_inventories WHERE user_id = ?)`+condition+`
+		ORDER BY it.name, it.id
+		LIMIT ?
+	`, append(append([]interface{}{userId}, args...), opts.limit)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var items []SearchResult
+	for rows.Next() {
+		item := SearchResult{Type: resultItem}
+		var url, thumbnail string
+		if err := rows.Scan(&item.ID, &item.Name, &url, &thumbnail, &item.FolderID, &item.InventoryID, &item.InventoryName); err != nil {
+			return nil, err
+		}
+		item.URL = "assets/" + url
+		item.ThumbnailURL = thumbnailPath(thumbnail)
+		items = append(items, item)
+	}
+	return items, rows.Err()
+}
+
+// folderPaths returns the path of each folder from the root of its
+// inventory, e.g. "/Avatars/Hats", with "/" for root folders. The ancestors
+// of all folders are loaded level by level, so shared ones are read once.
+func folderPaths(folderIds []int) (map[int]string, error) {
+	type folderInfo struct {
+		name     string
+		parentId int
+	}
+	folders := map[int]folderInfo{}
+
+	pending := folderIds
+	for len(pending) > 0 {
+		var ids []interface{}
+		for _, id := range pending {
+			if _, ok := folders[id]; !ok {
+				ids = append(ids, id)
+			}
+		}
+		pending = nil
+		if len(ids) == 0 {
+			break
+		}
+
+		rows, err := database.Db.Query("SELECT id, name, COALESCE(parent_folder_id, -1) FROM Folders WHERE id IN ("+database.Placeholders(len(ids))+")", ids...)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var id int
+			var info folderInfo
+			if err := rows.Scan(&id, &info.name, &info.parentId); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			folders[id] = info
+			if info.parentId >= 0 {
+				pending = append(pending, info.parentId)
+			}
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	paths := map[int]string{}
+	var pathOf func(id int, depth int) string
+	pathOf = func(id int, depth int) string {
+		if p, ok := paths[id]; ok {
+			return p
+		}
+		info, ok := folders[id]
+		// Stop at the root and at broken or cyclic parent links
+		if !ok || info.parentId < 0 || depth > len(folders) {
+			paths[id] = "/"
+			return "/"
+		}
+		p := path.Join(pathOf(info.parentId, depth+1), info.name)
+		paths[id] = p
+		return p
+	}
+	for _, id := range folderIds {
+		pathOf(id, 0)
+	}
+	return paths, nil
+}
+
+// searchJSON handles GET /api/search
+func searchJSON(w http.ResponseWriter, r *http.Request) {
+	claims, err := authentication.ParseToken(authentication.RequestToken(r))
+	if err != nil {
+		http.Error(w, "Auth token invalid or missing", http.StatusUnauthorized)
+		return
+	}
+
+	opts, err := parseSearchOptions(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Set JSON content type
+	w.Header().Set("Content-Type", "application/json")
+
+	results, err := search(claims.UID, opts)
+	if err != nil {
+		fmt.Println("[QUERY] Search failed:", err)
+		json.NewEncoder(w).Encode(SearchResponse{Success: false})
+		return
+	}
+	json.NewEncoder(w).Encode(SearchResponse{Success: true, Data: results})
+}
+
+// searchAnimx handles GET /query/search
+func searchAnimx(w http.ResponseWriter, r *http.Request) {
+	claims, err := authentication.ParseToken(r.URL.Query().Get("auth"))
+	if err != nil {
+		writeAnimationError(w, http.StatusUnauthorized, errorUnauthorized, "Auth token invalid or missing")
+		return
+	}
+
+	opts, err := parseSearchOptions(r.URL.Query())
+	if err != nil {
+		writeAnimationError(w, http.StatusBadRequest, errorInvalidParameter, err.Error())
+		return
+	}
+
+	results, err := search(claims.UID, opts)
+	if err != nil {
+		fmt.Println("[QUERY] Search failed:", err)
+		writeAnimationError(w, http.StatusInternalServerError, errorInternal, "Search failed")
+		return
+	}
+
+	var animxResults []animxSearchResult
+	for _, result := range results {
+		animxResults = append(animxResults, animxSearchResult{
+			Type:          result.Type,
+			ID:            int32(result.ID),
+			Name:          result.Name,
+			FolderID:      int32(result.FolderID),
+			Path:          result.Path,
+			InventoryID:   int32(result.InventoryID),
+			InventoryName: result.InventoryName,
+			URL:           result.URL,
+			ThumbnailURL:  result.ThumbnailURL,
+		})
+	}
+	tracks, err := animxmaker.Marshal(animxResults, "results")
+	if err != nil {
+		writeAnimationError(w, http.StatusInternalServerError, errorInternal, "Search failed")
+		return
+	}
+	countTrack := animxmaker.CountTrack(len(animxResults), "results")
+	writeAnimation(w, append(tracks, &countTrack))
+}
@@ -0,0 +1,132 @@
+package query
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"resonite-file-provider/animxmaker"
+	"resonite-file-provider/authentication"
+	"resonite-file-provider/database"
+	"strconv"
+)
+
+type TagsResponse struct {
+	Success bool      `json:"success"`
+	Data    []TagInfo `json:"data"`
+}
+
+// TagInfo is a tag in use in an inventory. Count is the number of items in
+// the inventory that have it.
+type TagInfo struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+// animxTag is a tag as listed in AnimX responses. The number of items is
+// itemCount, count already holds the number of tags.
+type animxTag struct {
+	Name      string `animx:"name"`
+	ItemCount int32  `animx:"itemCount"`
+}
+
+// hasInventoryAccess reports whether the user has any access to an inventory
+func hasInventoryAccess(inventoryId int, userId int) (bool, error) {
+	var count int
+	err := database.Db.QueryRow("SELECT COUNT(*) FROM users_inventories WHERE inventory_id = ? AND user_id = ?", inventoryId, userId).Scan(&count)
+	return count > 0, err
+}
+
+// inventoryTags lists the tags used by items in an inventory with the number
+// of items having each, most used first
+func inventoryTags(inventoryId int) ([]TagInfo, error) {
+	rows, err := database.Db.Query(`
+		SELECT t.name, COUNT(DISTINCT itg.item_id) AS item_count
+		FROM Tags t
+		INNER JOIN item_tags itg ON itg.tag_id = t.id
+		INNER JOIN Items it ON it.id = itg.item_id
+		INNER JOIN Folders f ON f.id = it.folder_id
+		WHERE f.inventory_id = ?
+		GROUP BY t.id, t.name
+		ORDER BY item_count DESC, t.name
+	`, inventoryId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tags := []TagInfo{}
+	for rows.Next() {
+		var tag TagInfo
+		if err := rows.Scan(&tag.Name, &tag.Count); err != nil {
+			return nil, err
+		}
+		tags = append(tags, tag)
+	}
+	return tags, rows.Err()
+}
+
+// listTagsJSON handles GET /api/tags
+func listTagsJSON(w http.ResponseWriter, r *http.Request) {
+	inventoryId, err := strconv.Atoi(r.URL.Query().Get("inventoryId"))
+	if err != nil {
+		http.Error(w, "inventoryId is either not specified or is invalid", http.StatusBadRequest)
+		return
+	}
+
+	claims, err := authentication.ParseToken(authentication.RequestToken(r))
+	if err != nil {
+		http.Error(w, "Auth token invalid or missing", http.StatusUnauthorized)
+		return
+	}
+	if allowed, err := hasInventoryAccess(inventoryId, claims.UID); err != nil || !allowed {
+		http.Error(w, "You don't have access to this inventory", http.StatusForbidden)
+		return
+	}
+
+	// Set JSON content type
+	w.Header().Set("Content-Type", "application/json")
+
+	tags, err := inventoryTags(inventoryId)
+	if err != nil {
+		fmt.Println("[QUERY] Failed to list tags:", err)
+		json.NewEncoder(w).Encode(TagsResponse{Success: false})
+		return
+	}
+	json.NewEncoder(w).Encode(TagsResponse{Success: true, Data: tags})
+}
+
+// listTags handles GET /query/tags
+func listTags(w http.ResponseWriter, r *http.Request) {
+	inventoryId, err := strconv.Atoi(r.URL.Query().Get("inventoryId"))
+	if err != nil {
+		writeAnimationError(w, http.StatusBadRequest, errorInvalidParameter, "inventoryId is either not specified or is invalid")
+		return
+	}
+	claims, err := authentication.ParseToken(r.URL.Query().Get("auth"))
+	if err != nil {
+		writeAnimationError(w, http.StatusUnauthorized, errorUnauthorized, "Auth token invalid or missing")
+		return
+	}
+	if allowed, err := hasInventoryAccess(inventoryId, claims.UID); err != nil || !allowed {
+		writeAnimationError(w, http.StatusForbidden, errorForbidden, "You don't have access to this inventory")
+		return
+	}
+
+	tags, err := inventoryTags(inventoryId)
+	if err != nil {
+		fmt.Println("[QUERY] Failed to list tags:", err)
+		writeAnimationError(w, http.StatusInternalServerError, errorInternal, "Error while getting tags")
+		return
+	}
+	var animxTags []animxTag
+	for _, tag := range tags {
+		animxTags = append(animxTags, animxTag{Name: tag.Name, ItemCount: int32(tag.Count)})
+	}
+	tracks, err := animxmaker.Marshal(animxTags, "results")
+	if err != nil {
+		writeAnimationError(w, http.StatusInternalServerError, errorInternal, "Error while getting tags")
+		return
+	}
+	countTrack := animxmaker.CountTrack(len(animxTags), "results")
+	writeAnimation(w, append(tracks, &countTrack))
+}
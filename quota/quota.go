@@ -0,0 +1,217 @@
+package quota
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"resonite-file-provider/config"
+	"resonite-file-provider/database"
+)
+
+// ErrExceeded is wrapped by errors for uploads that would exceed a quota
+var ErrExceeded = errors.New("storage quota exceeded")
+
+// Usage is the storage used by a user or inventory. QuotaBytes is 0 when the
+// storage is unlimited.
+type Usage struct {
+	UsedBytes  int64
+	QuotaBytes int64
+}
+
+// inventoryAssets selects the distinct assets used by items of an inventory
+const inventoryAssets = "SELECT hu.asset_id FROM `hash-usage` hu INNER JOIN Items it ON it.id = hu.item_id INNER JOIN Folders f ON f.id = it.folder_id WHERE f.inventory_id = ?"
+
+// InventoryUsage returns the bytes used by an inventory, counting every asset
+// once no matter how many of its items use it
+func InventoryUsage(inventoryId int) (Usage, error) {
+	return inventoryUsage(database.Db, inventoryId)
+}
+
+func inventoryUsage(q database.Queryer, inventoryId int) (Usage, error) {
+	usage := Usage{QuotaBytes: config.GetConfig().Quota.DefaultInventoryBytes}
+	err := q.QueryRow("SELECT COALESCE(SUM(size), 0) FROM Assets WHERE id IN ("+inventoryAssets+")", inventoryId).Scan(&usage.UsedBytes)
+	return usage, err
+}
+
+// UserQuota returns a user's quota, which is the admin set override if there
+// is one and the configured default otherwise
+func UserQuota(userId int) (int64, error) {
+	return userQuota(database.Db, userId)
+}
+
+func userQuota(q database.Queryer, userId int) (int64, error) {
+	var override sql.NullInt64
+	if err := q.QueryRow("SELECT quota_bytes FROM Users WHERE id = ?", userId).Scan(&override); err != nil {
+		return 0, err
+	}
+	if override.Valid {
+		return override.Int64, nil
+	}
+	return config.GetConfig().Quota.DefaultUserBytes, nil
+}
+
+// UserUsage returns the bytes used by all inventories a user owns
+func UserUsage(userId int) (Usage, error) {
+	return userUsage(database.Db, userId)
+}
+
+func userUsage(q database.Queryer, userId int) (Usage, error) {
+	quotaBytes, err := userQuota(q, userId)
+	if err != nil {
+		return Usage{}, err
+	}
+	usage := Usage{QuotaBytes: quotaBytes}
+	rows, err := q.Query("SELECT inventory_id FROM users_inventories WHERE user_id = ? AND access_level = 'owner'", userId)
+	if err != nil {
+		return Usage{}, err
+	}
+	var inventoryIds []int
+	for rows.Next() {
+		var inventoryId int
+		if err := rows.Scan(&inventoryId); err != nil {
+			rows.Close()
+			return Usage{}, err
+		}
+		inventoryIds = append(inventoryIds, inventoryId)
+	}
+	rows.Close()
+	for _, inventoryId := range inventoryIds {
+		inventory, err := inventoryUsage(q, inventoryId)
+		if err != nil {
+			return Usage{}, err
+		}
+		usage.UsedBytes += inventory.UsedBytes
+	}
+	return usage, nil
+}
+
+// SetUserQuota overrides a user's quota, or restores the default when
+// quotaBytes is negative
+func SetUserQuota(userId int, quotaBytes int64) error {
+	var value interface{}
+	if quotaBytes >= 0 {
+		value = quotaBytes
+	}
+	var exists bool
+	if err := database.Db.QueryRow("SELECT EXISTS(SELECT 1 FROM Users WHERE id = ?)", userId).Scan(&exists); err != nil {
+		return err
+	}
+	if !exists {
+		return sql.ErrNoRows
+	}
+	_, err := database.Db.Exec("UPDATE Users SET quota_bytes = ? WHERE id = ?", value, userId)
+	return err
+}
+
+// IsAdmin reports whether a user may manage other users' quotas
+func IsAdmin(userId int) (bool, error) {
+	var isAdmin bool
+	err := database.Db.QueryRow("SELECT is_admin FROM Users WHERE id = ?", userId).Scan(&isAdmin)
+	return isAdmin, err
+}
+
+func exceeds(usage Usage, incoming int64) bool {
+	return usage.QuotaBytes > 0 && usage.UsedBytes+incoming > usage.QuotaBytes
+}
+
+// CheckUpload verifies that storing assets of the given sizes, keyed by hash,
+// in a folder keeps its inventory and the inventory's owners within their
+// quotas. Assets the inventory already uses don't count again.
+func CheckUpload(folderId int, sizes map[string]int64) error {
+	return CheckUploadIn(database.Db, folderId, sizes)
+}
+
+// CheckUploadIn is CheckUpload reading through q, which lets a transaction
+// check the quotas as it sees them. Transactions that go on to store the
+// assets take LockInventory first so concurrent ones can't both pass.
+func CheckUploadIn(q database.Queryer, folderId int, sizes map[string]int64) error {
+	var inventoryId int
+	if err := q.QueryRow("SELECT inventory_id FROM Folders WHERE id = ?", folderId).Scan(&inventoryId); err != nil {
+		return err
+	}
+	if len(sizes) == 0 {
+		return nil
+	}
+
+	hashes := make([]interface{}, 0, len(sizes)+1)
+	hashes = append(hashes, inventoryId)
+	for hash := range sizes {
+		hashes = append(hashes, hash)
+	}
+	rows, err := q.Query("SELECT hash FROM Assets WHERE id IN ("+inventoryAssets+") AND hash IN ("+database.Placeholders(len(sizes))+")", hashes...)
+	if err != nil {
+		return err
+	}
+	present := make(map[string]bool)
+	for rows.Next() {
+		var hash string
+		if err := rows.Scan(&hash); err != nil {
+			rows.Close()
+			return err
+		}
+		present[hash] = true
+	}
+	rows.Close()
+	var incoming int64
+	for hash, size := range sizes {
+		if !present[hash] {
+			incoming += size
+		}
+	}
+
+	inventory, err := inventoryUsage(q, inventoryId)
+	if err != nil {
+		return err
+	}
+	if exceeds(inventory, incoming) {
+		return fmt.Errorf("%w: inventory uses %d of %d bytes and the upload needs %d more", ErrExceeded, inventory.UsedBytes, inventory.QuotaBytes, incoming)
+	}
+
+	owners, err := q.Query("SELECT user_id FROM users_inventories WHERE inventory_id = ? AND access_level = 'owner'", inventoryId)
+	if err != nil {
+		return err
+	}
+	var ownerIds []int
+	for owners.Next() {
+		var ownerId int
+		if err := owners.Scan(&ownerId); err != nil {
+			owners.Close()
+			return err
+		}
+		ownerIds = append(ownerIds, ownerId)
+	}
+	owners.Close()
+	for _, ownerId := range ownerIds {
+		owner, err := userUsage(q, ownerId)
+		if err != nil {
+			return err
+		}
+		if exceeds(owner, incoming) {
+			return fmt.Errorf("%w: owner uses %d of %d bytes and the upload needs %d more", ErrExceeded, owner.UsedBytes, owner.QuotaBytes, incoming)
+		}
+	}
+	return nil
+}
+
+// LockInventory locks the inventory of a folder and the users owning it until
+// tx ends, so transactions storing into the same inventory or for the same
+// owner check their quotas one after another. InnoDB reads a transaction's
+// plain SELECTs from a snapshot taken at the first of them, so it has to be
+// called before any or CheckUploadIn won't see what the others committed.
+func LockInventory(tx *sql.Tx, folderId int) error {
+	var inventoryId int
+	if err := tx.QueryRow("SELECT inventory_id FROM Folders WHERE id = ? LOCK IN SHARE MODE", folderId).Scan(&inventoryId); err != nil {
+		return err
+	}
+	for _, lock := range []string{
+		"SELECT id FROM Inventories WHERE id = ? FOR UPDATE",
+		"SELECT u.id FROM Users u INNER JOIN users_inventories ui ON ui.user_id = u.id WHERE ui.inventory_id = ? AND ui.access_level = 'owner' ORDER BY u.id FOR UPDATE",
+	} {
+		rows, err := tx.Query(lock, inventoryId)
+		if err != nil {
+			return err
+		}
+		rows.Close()
+	}
+	return nil
+}
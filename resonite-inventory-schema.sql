@@ -7,6 +7,8 @@ CREATE TABLE `Users` (
   `id` int(11) NOT NULL AUTO_INCREMENT,
   `username` text NOT NULL,
   `auth` varchar(256) NOT NULL,
+  `is_admin` tinyint(1) NOT NULL DEFAULT 0,
+  `quota_bytes` bigint(20) DEFAULT NULL,
   PRIMARY KEY (`id`)
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8 COLLATE=utf8_bin;
 
@@ -22,6 +24,7 @@ CREATE TABLE `users_inventories` (
   `id` int(11) NOT NULL AUTO_INCREMENT,
   `user_id` int(11) NOT NULL,
   `inventory_id` int(11) NOT NULL,
+  `access_level` enum('owner','editor','viewer') NOT NULL DEFAULT 'owner',
   PRIMARY KEY (`id`),
   KEY `inventory_id` (`inventory_id`),
   KEY `user_id` (`user_id`),
@@ -33,10 +36,12 @@ CREATE TABLE `users_inventories` (
 CREATE TABLE `Folders` (
   `id` int(11) NOT NULL AUTO_INCREMENT,
   `name` text NOT NULL,
-  `parent_folder_id` int(11) NOT NULL,
+  `parent_folder_id` int(11) DEFAULT NULL,
   `inventory_id` int(11) NOT NULL,
+  `created_at` timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
   PRIMARY KEY (`id`),
   KEY `inventoryId` (`inventory_id`),
+  FULLTEXT KEY `Folders_name_search` (`name`),
   CONSTRAINT `Folders_ibfk_1` FOREIGN KEY (`inventory_id`) REFERENCES `Inventories` (`id`)
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8 COLLATE=utf8_bin;
 
@@ -46,8 +51,11 @@ CREATE TABLE `Items` (
   `name` text NOT NULL,
   `folder_id` int(11) NOT NULL,
   `url` text NOT NULL,
+  `thumbnail_url` text DEFAULT NULL,
+  `created_at` timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
   PRIMARY KEY (`id`),
   KEY `Items_ibfk_1` (`folder_id`),
+  FULLTEXT KEY `Items_name_search` (`name`),
   CONSTRAINT `Items_ibfk_1` FOREIGN KEY (`folder_id`) REFERENCES `Folders` (`id`)
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8 COLLATE=utf8_bin;
 
@@ -55,6 +63,9 @@ CREATE TABLE `Items` (
 CREATE TABLE `Assets` (
   `id` int(11) NOT NULL AUTO_INCREMENT,
   `hash` text NOT NULL,
+  `size` bigint(20) NOT NULL DEFAULT 0,
+  `content_type` varchar(255) DEFAULT NULL,
+  `kind` enum('texture','mesh','audio','record','other') DEFAULT NULL,
   PRIMARY KEY (`id`),
   UNIQUE KEY `hash` (`hash`) USING HASH
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8 COLLATE=utf8_bin;
@@ -75,7 +86,8 @@ CREATE TABLE `hash-usage` (
 CREATE TABLE `Tags` (
   `id` int(11) NOT NULL AUTO_INCREMENT,
   `name` text NOT NULL,
-  PRIMARY KEY (`id`)
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `Tags_name` (`name`(255))
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8 COLLATE=utf8_bin;
 
 -- Item tags relationship table
@@ -99,5 +111,5 @@ CREATE TABLE `asset_tags` (
   KEY `asset_id` (`asset_id`),
   KEY `tag_id` (`tag_id`),
   CONSTRAINT `asset_tags_ibfk_1` FOREIGN KEY (`asset_id`) REFERENCES `Assets` (`id`),
-  CONSTRAINT `asset_tags_ibfk_2` FOREIGN KEY (`tag_id`) REFERENCES `item_tags` (`id`)
+  CONSTRAINT `asset_tags_ibfk_2` FOREIGN KEY (`tag_id`) REFERENCES `Tags` (`id`)
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8 COLLATE=utf8_bin;
\ No newline at end of file
@@ -0,0 +1,125 @@
+package storage
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// Local stores objects as files in a directory
+type Local struct {
+	root string
+}
+
+// NewLocal returns a backend storing objects below root, creating it if needed
+func NewLocal(root string) (*Local, error) {
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return nil, err
+	}
+	return &Local{root: root}, nil
+}
+
+func (l *Local) path(name string) string {
+	return filepath.Join(l.root, filepath.FromSlash(name))
+}
+
+func mapNotExist(err error) error {
+	if errors.Is(err, fs.ErrNotExist) {
+		return ErrNotExist
+	}
+	return err
+}
+
+func (l *Local) Put(name string, r io.Reader, size int64) error {
+	target := l.path(name)
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+	// Write to a temporary file first so readers never see partial objects
+	tmp, err := os.CreateTemp(filepath.Dir(target), ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := io.CopyN(tmp, r, size); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(0644); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), target)
+}
+
+type limitedFile struct {
+	io.Reader
+	file *os.File
+}
+
+func (f limitedFile) Close() error {
+	return f.file.Close()
+}
+
+func (l *Local) Get(name string, offset int64, length int64) (io.ReadCloser, error) {
+	file, err := os.Open(l.path(name))
+	if err != nil {
+		return nil, mapNotExist(err)
+	}
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		file.Close()
+		return nil, err
+	}
+	if length < 0 {
+		return file, nil
+	}
+	return limitedFile{Reader: io.LimitReader(file, length), file: file}, nil
+}
+
+func (l *Local) Stat(name string) (ObjectInfo, error) {
+	info, err := os.Stat(l.path(name))
+	if err != nil {
+		return ObjectInfo{}, mapNotExist(err)
+	}
+	if info.IsDir() {
+		return ObjectInfo{}, ErrNotExist
+	}
+	return ObjectInfo{Name: name, Size: info.Size(), ModTime: info.ModTime()}, nil
+}
+
+func (l *Local) Delete(name string) error {
+	return mapNotExist(os.Remove(l.path(name)))
+}
+
+func (l *Local) Move(from string, to string) error {
+	target := l.path(to)
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+	return mapNotExist(os.Rename(l.path(from), target))
+}
+
+func (l *Local) List(fn func(ObjectInfo) error) error {
+	return filepath.WalkDir(l.root, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() || entry.Name()[0] == '.' {
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		name, err := filepath.Rel(l.root, path)
+		if err != nil {
+			return err
+		}
+		return fn(ObjectInfo{Name: filepath.ToSlash(name), Size: info.Size(), ModTime: info.ModTime()})
+	})
+}
@@ -0,0 +1,163 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// HashLength is the length of an asset hash, a hex encoded SHA-256
+const HashLength = 64
+
+// IsHash reports whether name is an asset hash: lowercase hex of HashLength
+// characters
+func IsHash(name string) bool {
+	if len(name) != HashLength {
+		return false
+	}
+	for _, c := range name {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// RecordSuffix is appended to the hash of a record to name its object
+const RecordSuffix = ".brson"
+
+// ErrInvalidName is returned by Sharded for names that are neither an asset
+// hash nor the hash of a record followed by RecordSuffix
+var ErrInvalidName = errors.New("object name is not an asset hash")
+
+// validName reports whether Sharded stores objects under name
+func validName(name string) bool {
+	return IsHash(strings.TrimSuffix(name, RecordSuffix))
+}
+
+// ShardKey returns the content-addressed key of an object, which nests it two
+// directories deep by hash prefix (ab/cd/abcdef...) so no single directory
+// has to hold every asset. Records share the directory of their hash.
+func ShardKey(name string) string {
+	if len(name) < 4 || strings.Contains(name, "/") {
+		return name
+	}
+	return name[0:2] + "/" + name[2:4] + "/" + name
+}
+
+// Sharded stores objects of a backend under their ShardKey while still
+// finding objects written in the old flat layout. It only accepts asset hashes
+// and record names as names, so no name can reach outside its shard.
+type Sharded struct {
+	Backend Backend
+}
+
+// NewSharded returns a sharded view of backend
+func NewSharded(backend Backend) *Sharded {
+	return &Sharded{Backend: backend}
+}
+
+func (s *Sharded) Put(name string, r io.Reader, size int64) error {
+	if !validName(name) {
+		return ErrInvalidName
+	}
+	return s.Backend.Put(ShardKey(name), r, size)
+}
+
+func (s *Sharded) Get(name string, offset int64, length int64) (io.ReadCloser, error) {
+	if !validName(name) {
+		return nil, ErrInvalidName
+	}
+	reader, err := s.Backend.Get(ShardKey(name), offset, length)
+	if err == ErrNotExist {
+		return s.Backend.Get(name, offset, length)
+	}
+	return reader, err
+}
+
+func (s *Sharded) Stat(name string) (ObjectInfo, error) {
+	if !validName(name) {
+		return ObjectInfo{}, ErrInvalidName
+	}
+	info, err := s.Backend.Stat(ShardKey(name))
+	if err == ErrNotExist {
+		info, err = s.Backend.Stat(name)
+	}
+	info.Name = name
+	return info, err
+}
+
+func (s *Sharded) Delete(name string) error {
+	if !validName(name) {
+		return ErrInvalidName
+	}
+	err := s.Backend.Delete(ShardKey(name))
+	if err == ErrNotExist {
+		return s.Backend.Delete(name)
+	}
+	return err
+}
+
+// List skips objects Sharded can't address, such as stray files in a local
+// assets directory
+func (s *Sharded) List(fn func(ObjectInfo) error) error {
+	return s.Backend.List(func(info ObjectInfo) error {
+		info.Name = info.Name[strings.LastIndex(info.Name, "/")+1:]
+		if !validName(info.Name) {
+			return nil
+		}
+		return fn(info)
+	})
+}
+
+// Mover is implemented by backends that can move objects without copying them
+type Mover interface {
+	Move(from string, to string) error
+}
+
+// MigrateLayout moves every object still stored in the flat layout to its
+// ShardKey and returns how many objects were (or would be) moved. Files that
+// aren't named like an object are left alone.
+func MigrateLayout(backend Backend, dryRun bool, log func(format string, args ...any)) (int, error) {
+	var flat []ObjectInfo
+	err := backend.List(func(info ObjectInfo) error {
+		if validName(info.Name) && ShardKey(info.Name) != info.Name {
+			flat = append(flat, info)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	if dryRun {
+		for _, info := range flat {
+			log("would move %s to %s", info.Name, ShardKey(info.Name))
+		}
+		return len(flat), nil
+	}
+
+	for i, info := range flat {
+		if err := moveObject(backend, info, ShardKey(info.Name)); err != nil {
+			return i, fmt.Errorf("failed to move %s: %w", info.Name, err)
+		}
+		log("moved %s to %s", info.Name, ShardKey(info.Name))
+	}
+	return len(flat), nil
+}
+
+func moveObject(backend Backend, info ObjectInfo, to string) error {
+	if mover, ok := backend.(Mover); ok {
+		return mover.Move(info.Name, to)
+	}
+	reader, err := backend.Get(info.Name, 0, -1)
+	if err != nil {
+		return err
+	}
+	err = backend.Put(to, reader, info.Size)
+	reader.Close()
+	if err != nil {
+		return err
+	}
+	return backend.Delete(info.Name)
+}
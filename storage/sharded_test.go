@@ -0,0 +1,165 @@
+package storage
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+const (
+	testHash  = "abcdef0123456789abcdef0123456789abcdef0123456789abcdef0123456789"
+	otherHash = "0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+)
+
+func TestShardKey(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{testHash, "ab/cd/" + testHash},
+		{testHash + ".brson", "ab/cd/" + testHash + ".brson"},
+		{"abcd", "ab/cd/abcd"},
+		// Short and already nested names are kept
+		{"abc", "abc"},
+		{"", ""},
+		{"ab/cd/" + testHash, "ab/cd/" + testHash},
+	}
+	for _, tt := range tests {
+		if got := ShardKey(tt.name); got != tt.want {
+			t.Errorf("ShardKey(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestIsHash(t *testing.T) {
+	tests := map[string]bool{
+		testHash:                  true,
+		strings.ToUpper(testHash): false,
+		testHash[:63]:             false,
+		testHash + "0":            false,
+		testHash[:63] + "g":       false,
+		testHash + ".brson":       false,
+		"../" + testHash[3:]:      false,
+		"ab/cd/" + testHash[6:]:   false,
+	}
+	for name, want := range tests {
+		if got := IsHash(name); got != want {
+			t.Errorf("IsHash(%q) = %v, want %v", name, got, want)
+		}
+	}
+}
+
+func newTestLocal(t *testing.T) (*Local, string) {
+	t.Helper()
+	root := t.TempDir()
+	local, err := NewLocal(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return local, root
+}
+
+func TestShardedRejectsInvalidNames(t *testing.T) {
+	local, _ := newTestLocal(t)
+	sharded := NewSharded(local)
+	for _, name := range []string{"", "../../etc/passwd", "ab/cd/" + testHash, strings.ToUpper(testHash), testHash + ".txt", "thumbnail.webp"} {
+		if err := sharded.Put(name, strings.NewReader("x"), 1); !errors.Is(err, ErrInvalidName) {
+			t.Errorf("Put(%q) = %v, want ErrInvalidName", name, err)
+		}
+		if _, err := sharded.Get(name, 0, -1); !errors.Is(err, ErrInvalidName) {
+			t.Errorf("Get(%q) = %v, want ErrInvalidName", name, err)
+		}
+		if _, err := sharded.Stat(name); !errors.Is(err, ErrInvalidName) {
+			t.Errorf("Stat(%q) = %v, want ErrInvalidName", name, err)
+		}
+		if err := sharded.Delete(name); !errors.Is(err, ErrInvalidName) {
+			t.Errorf("Delete(%q) = %v, want ErrInvalidName", name, err)
+		}
+	}
+
+	for _, name := range []string{testHash, testHash + ".brson"} {
+		if err := sharded.Put(name, strings.NewReader("data"), 4); err != nil {
+			t.Fatalf("Put(%q): %v", name, err)
+		}
+		data, err := ReadAll(sharded, name)
+		if err != nil || string(data) != "data" {
+			t.Errorf("ReadAll(%q) = %q, %v", name, data, err)
+		}
+	}
+}
+
+// listNames returns the names a backend lists, sorted
+func listNames(t *testing.T, backend Backend) []string {
+	t.Helper()
+	var names []string
+	if err := backend.List(func(info ObjectInfo) error {
+		names = append(names, info.Name)
+		return nil
+	}); err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestMigrateLayout(t *testing.T) {
+	local, root := newTestLocal(t)
+	flat := map[string]string{
+		testHash:             "asset",
+		otherHash + ".brson": "record",
+		"notes.txt":          "not an object",
+	}
+	for name, data := range flat {
+		if err := os.WriteFile(filepath.Join(root, name), []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Already sharded objects stay where they are
+	if err := local.Put(ShardKey(otherHash), strings.NewReader("sharded"), 7); err != nil {
+		t.Fatal(err)
+	}
+	logf := func(string, ...any) {}
+
+	if moved, err := MigrateLayout(local, true, logf); err != nil || moved != 2 {
+		t.Fatalf("dry run = %d, %v, want 2", moved, err)
+	}
+	if _, err := local.Stat(testHash); err != nil {
+		t.Fatalf("dry run moved %s: %v", testHash, err)
+	}
+
+	if moved, err := MigrateLayout(local, false, logf); err != nil || moved != 2 {
+		t.Fatalf("MigrateLayout = %d, %v, want 2", moved, err)
+	}
+	want := []string{
+		"01/23/" + otherHash,
+		"01/23/" + otherHash + ".brson",
+		"ab/cd/" + testHash,
+		"notes.txt",
+	}
+	if got := listNames(t, local); !reflect.DeepEqual(got, want) {
+		t.Errorf("after migration = %v, want %v", got, want)
+	}
+
+	// A second run finds nothing left to move
+	if moved, err := MigrateLayout(local, false, logf); err != nil || moved != 0 {
+		t.Fatalf("second MigrateLayout = %d, %v, want 0", moved, err)
+	}
+	if got := listNames(t, local); !reflect.DeepEqual(got, want) {
+		t.Errorf("after second migration = %v, want %v", got, want)
+	}
+
+	sharded := NewSharded(local)
+	for name, data := range map[string]string{testHash: "asset", otherHash + ".brson": "record", otherHash: "sharded"} {
+		got, err := ReadAll(sharded, name)
+		if err != nil || string(got) != data {
+			t.Errorf("ReadAll(%q) = %q, %v, want %q", name, got, err, data)
+		}
+	}
+	if got, want := listNames(t, sharded), []string{otherHash, otherHash + ".brson", testHash}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Sharded List = %v, want %v", got, want)
+	}
+}
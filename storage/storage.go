@@ -0,0 +1,135 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"resonite-file-provider/config"
+	"time"
+)
+
+// ErrNotExist is returned when an object is not in the backend
+var ErrNotExist = errors.New("object does not exist")
+
+// ObjectInfo describes a stored object
+type ObjectInfo struct {
+	Name    string
+	Size    int64
+	ModTime time.Time
+}
+
+// Backend stores asset and record bytes by name
+type Backend interface {
+	// Put stores size bytes read from r under name, replacing any existing object
+	Put(name string, r io.Reader, size int64) error
+	// Get returns length bytes of an object starting at offset. A negative
+	// length reads to the end of the object.
+	Get(name string, offset int64, length int64) (io.ReadCloser, error)
+	// Stat describes an object without reading it
+	Stat(name string) (ObjectInfo, error)
+	// Delete removes an object
+	Delete(name string) error
+	// List calls fn for every stored object, stopping at the first error
+	List(fn func(ObjectInfo) error) error
+}
+
+// Assets holds assets and records by hash in the sharded layout, on the
+// backend selected in config.toml. It is set up by Init.
+var Assets *Sharded
+
+// Init creates the storage backend selected in config.toml
+func Init() error {
+	cfg := config.GetConfig()
+	switch cfg.Storage.Backend {
+	case "", "local":
+		backend, err := NewLocal(cfg.Server.AssetsPath)
+		if err != nil {
+			return err
+		}
+		Assets = NewSharded(backend)
+	case "s3":
+		backend, err := NewS3(cfg.Storage.S3)
+		if err != nil {
+			return err
+		}
+		Assets = NewSharded(backend)
+	default:
+		return fmt.Errorf("unknown storage backend %q", cfg.Storage.Backend)
+	}
+	return nil
+}
+
+// ReadAll reads a whole object into memory
+func ReadAll(backend Backend, name string) ([]byte, error) {
+	reader, err := backend.Get(name, 0, -1)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	return io.ReadAll(reader)
+}
+
+// ReadSeeker reads an object of known size through ranged Gets, so it can be
+// handed to http.ServeContent regardless of the backend
+type ReadSeeker struct {
+	backend Backend
+	name    string
+	size    int64
+	offset  int64
+	body    io.ReadCloser
+}
+
+// NewReadSeeker returns a ReadSeeker over an object of the given size
+func NewReadSeeker(backend Backend, name string, size int64) *ReadSeeker {
+	return &ReadSeeker{backend: backend, name: name, size: size}
+}
+
+func (r *ReadSeeker) Read(p []byte) (int, error) {
+	if r.offset >= r.size {
+		return 0, io.EOF
+	}
+	if r.body == nil {
+		body, err := r.backend.Get(r.name, r.offset, r.size-r.offset)
+		if err != nil {
+			return 0, err
+		}
+		r.body = body
+	}
+	n, err := r.body.Read(p)
+	r.offset += int64(n)
+	if err == io.EOF && r.offset < r.size {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+func (r *ReadSeeker) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.offset
+	case io.SeekEnd:
+		offset += r.size
+	default:
+		return 0, errors.New("invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+	if offset != r.offset {
+		// The open body only serves the old position
+		r.Close()
+		r.offset = offset
+	}
+	return offset, nil
+}
+
+// Close releases the body of the current ranged Get, if any
+func (r *ReadSeeker) Close() error {
+	if r.body == nil {
+		return nil
+	}
+	err := r.body.Close()
+	r.body = nil
+	return err
+}
@@ -0,0 +1,90 @@
+package upload
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"resonite-file-provider/authentication"
+	"resonite-file-provider/quota"
+	"strconv"
+)
+
+// HandleSetQuota handles POST /admin/quota, which lets admins override a
+// user's storage quota. An empty quotaBytes restores the configured default.
+func HandleSetQuota(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodPost {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": false,
+			"error":   "Invalid request method",
+		})
+		return
+	}
+	claims, err := authentication.ParseToken(authentication.RequestToken(r))
+	if err != nil {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": false,
+			"error":   "Auth token invalid or missing",
+		})
+		return
+	}
+	if isAdmin, err := quota.IsAdmin(claims.UID); err != nil || !isAdmin {
+		fmt.Println("[QUOTA] Non-admin user", claims.Username, "tried to set a quota")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": false,
+			"error":   "Only admins can set quotas",
+		})
+		return
+	}
+
+	userId, err := strconv.Atoi(r.URL.Query().Get("userId"))
+	if err != nil {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": false,
+			"error":   "userId missing or invalid",
+		})
+		return
+	}
+	// Zero means unlimited in the configured defaults and usage reports, so
+	// it isn't accepted as a limit. Restoring the default is done by leaving
+	// quotaBytes empty.
+	quotaBytes := int64(-1)
+	if value := r.URL.Query().Get("quotaBytes"); value != "" {
+		quotaBytes, err = strconv.ParseInt(value, 10, 64)
+		if err != nil || quotaBytes <= 0 {
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"success": false,
+				"error":   "quotaBytes must be a positive number of bytes",
+			})
+			return
+		}
+	}
+
+	if err := quota.SetUserQuota(userId, quotaBytes); err == sql.ErrNoRows {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": false,
+			"error":   "User not found",
+		})
+		return
+	} else if err != nil {
+		fmt.Println("[QUOTA] Failed to set quota for user", userId, ":", err.Error())
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": false,
+			"error":   "Failed to set quota: " + err.Error(),
+		})
+		return
+	}
+
+	effectiveQuota, err := quota.UserQuota(userId)
+	if err != nil {
+		effectiveQuota = quotaBytes
+	}
+	fmt.Println("[QUOTA] User", claims.Username, "set quota of user", userId, "to", effectiveQuota)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":    true,
+		"userId":     userId,
+		"quotaBytes": effectiveQuota,
+	})
+}
@@ -0,0 +1,409 @@
+package upload
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"resonite-file-provider/authentication"
+	"resonite-file-provider/database"
+	"resonite-file-provider/query"
+	"resonite-file-provider/storage"
+	"strconv"
+	"strings"
+)
+
+// archiveVersion is bumped whenever the layout of inventory archives changes
+const archiveVersion = 1
+
+// Inventory archives are zips holding a manifest.json, one neutral record per
+// item under records/ and every asset once under assets/, named by hash.
+type archiveManifest struct {
+	Version      int             `json:"version"`
+	RootFolderId int             `json:"rootFolderId"`
+	Folders      []archiveFolder `json:"folders"`
+	Items        []archiveItem   `json:"items"`
+}
+
+type archiveFolder struct {
+	ID       int    `json:"id"`
+	ParentID int    `json:"parentId"`
+	Name     string `json:"name"`
+}
+
+type archiveItem struct {
+	ID        int      `json:"id"`
+	FolderID  int      `json:"folderId"`
+	Name      string   `json:"name"`
+	Record    string   `json:"record"`
+	Thumbnail string   `json:"thumbnail,omitempty"`
+	Assets    []string `json:"assets"`
+	Tags      []string `json:"tags"`
+}
+
+// buildArchiveManifest collects the folder subtree rooted at rootFolderId
+// together with its items
+func buildArchiveManifest(rootFolderId int) (*archiveManifest, error) {
+	manifest := &archiveManifest{
+		Version:      archiveVersion,
+		RootFolderId: rootFolderId,
+	}
+	var rootName string
+	if err := database.Db.QueryRow("SELECT name FROM Folders WHERE id = ?", rootFolderId).Scan(&rootName); err != nil {
+		return nil, err
+	}
+	manifest.Folders = append(manifest.Folders, archiveFolder{ID: rootFolderId, Name: rootName})
+
+	// Folders are appended breadth first, so parents always precede children
+	for i := 0; i < len(manifest.Folders); i++ {
+		folderId := manifest.Folders[i].ID
+		childFolders, err := database.Db.Query("SELECT id, name FROM Folders WHERE parent_folder_id = ?", folderId)
+		if err != nil {
+			return nil, err
+		}
+		for childFolders.Next() {
+			folder := archiveFolder{ParentID: folderId}
+			if err := childFolders.Scan(&folder.ID, &folder.Name); err != nil {
+				childFolders.Close()
+				return nil, err
+			}
+			manifest.Folders = append(manifest.Folders, folder)
+		}
+		childFolders.Close()
+
+		items, err := database.Db.Query("SELECT id, name, url, COALESCE(thumbnail_url, '') FROM Items WHERE folder_id = ?", folderId)
+		if err != nil {
+			return nil, err
+		}
+		for items.Next() {
+			item := archiveItem{FolderID: folderId}
+			if err := items.Scan(&item.ID, &item.Name, &item.Record, &item.Thumbnail); err != nil {
+				items.Close()
+				return nil, err
+			}
+			manifest.Items = append(manifest.Items, item)
+		}
+		items.Close()
+	}
+
+	for i := range manifest.Items {
+		item := &manifest.Items[i]
+		hashes, err := itemAssetHashes(item.ID)
+		if err != nil {
+			return nil, err
+		}
+		for _, hash := range hashes {
+			if hash != item.Record {
+				item.Assets = append(item.Assets, hash)
+			}
+		}
+		if item.Tags, err = itemTagNames(item.ID); err != nil {
+			return nil, err
+		}
+	}
+	return manifest, nil
+}
+
+// HandleExportFolder handles GET /export/folder and streams a folder subtree,
+// or a whole inventory when inventoryId is given, as an inventory archive
+func HandleExportFolder(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+	claims, err := authentication.ParseToken(authentication.RequestToken(r))
+	if err != nil {
+		http.Error(w, "Auth token invalid or missing", http.StatusUnauthorized)
+		return
+	}
+
+	var folderId int
+	if inventoryId := r.URL.Query().Get("inventoryId"); inventoryId != "" {
+		err = database.Db.QueryRow("SELECT id FROM Folders WHERE inventory_id = ? AND parent_folder_id IS NULL LIMIT 1", inventoryId).Scan(&folderId)
+		if err != nil {
+			http.Error(w, "inventoryId is invalid", http.StatusBadRequest)
+			return
+		}
+	} else if folderId, err = strconv.Atoi(r.URL.Query().Get("folderId")); err != nil {
+		http.Error(w, "folderId or inventoryId must be specified", http.StatusBadRequest)
+		return
+	}
+	if allowed, err := query.CheckFolderAccess(folderId, claims.UID, "viewer"); err != nil || !allowed {
+		http.Error(w, "You don't have access to this folder", http.StatusForbidden)
+		return
+	}
+
+	manifest, err := buildArchiveManifest(folderId)
+	if err != nil {
+		http.Error(w, "Failed to read folder tree", http.StatusInternalServerError)
+		return
+	}
+	manifestData, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		http.Error(w, "Failed to encode manifest", http.StatusInternalServerError)
+		return
+	}
+
+	// From here on the response is streamed. A failure aborts the connection
+	// without finishing the zip, as in HandleExportItem.
+	fileName := strings.ReplaceAll(manifest.Folders[0].Name, `"`, "") + ".zip"
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, fileName))
+
+	zipWriter := zip.NewWriter(w)
+	entry, err := zipWriter.Create("manifest.json")
+	if err == nil {
+		_, err = entry.Write(manifestData)
+	}
+	if err != nil {
+		fmt.Println("[EXPORT] Failed to write manifest of folder", folderId, ":", err)
+		panic(http.ErrAbortHandler)
+	}
+	written := make(map[string]bool)
+	for _, item := range manifest.Items {
+		if !written["records/"+item.Record] {
+			record, err := exportRecord(item.Record)
+			if err == nil {
+				entry, err = zipWriter.Create("records/" + item.Record + ".brson")
+			}
+			if err == nil {
+				_, err = entry.Write(record)
+			}
+			if err != nil {
+				fmt.Println("[EXPORT] Failed to write record of item", item.ID, ":", err)
+				panic(http.ErrAbortHandler)
+			}
+			written["records/"+item.Record] = true
+		}
+		for _, hash := range item.Assets {
+			if written["assets/"+hash] {
+				continue
+			}
+			if err := writeZipFile(zipWriter, "assets/"+hash, hash); err != nil {
+				fmt.Println("[EXPORT] Failed to write asset", hash, "of item", item.ID, ":", err)
+				panic(http.ErrAbortHandler)
+			}
+			written["assets/"+hash] = true
+		}
+	}
+	if err := zipWriter.Close(); err != nil {
+		fmt.Println("[EXPORT] Failed to finish archive of folder", folderId, ":", err)
+		panic(http.ErrAbortHandler)
+	}
+}
+
+func readZipEntry(f *zip.File) ([]byte, error) {
+	file, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return io.ReadAll(file)
+}
+
+// importArchive recreates the tree of an inventory archive below
+// targetFolderId and returns the id of the new top folder
+func importArchive(zipReader *zip.Reader, targetFolderId int) (rootFolderId int64, itemCount int, err error) {
+	entries := make(map[string]*zip.File)
+	for _, f := range zipReader.File {
+		entries[f.Name] = f
+	}
+	manifestEntry, ok := entries["manifest.json"]
+	if !ok {
+		return 0, 0, fmt.Errorf("archive has no manifest.json")
+	}
+	manifestData, err := readZipEntry(manifestEntry)
+	if err != nil {
+		return 0, 0, err
+	}
+	var manifest archiveManifest
+	if err := json.Unmarshal(manifestData, &manifest); err != nil {
+		return 0, 0, fmt.Errorf("invalid manifest: %w", err)
+	}
+	if manifest.Version != archiveVersion {
+		return 0, 0, fmt.Errorf("unsupported archive version %d", manifest.Version)
+	}
+	if len(manifest.Folders) == 0 || manifest.Folders[0].ID != manifest.RootFolderId {
+		return 0, 0, fmt.Errorf("manifest does not start with its root folder")
+	}
+	// Hashes become storage names, so anything but a plain hash is rejected
+	// before a single folder is created
+	for _, item := range manifest.Items {
+		if !storage.IsHash(item.Record) {
+			return 0, 0, fmt.Errorf("item %s has an invalid record hash", item.Name)
+		}
+		if item.Thumbnail != "" && !storage.IsHash(item.Thumbnail) {
+			return 0, 0, fmt.Errorf("item %s has an invalid thumbnail hash", item.Name)
+		}
+		for _, hash := range item.Assets {
+			if !storage.IsHash(hash) {
+				return 0, 0, fmt.Errorf("item %s has an invalid asset hash", item.Name)
+			}
+		}
+	}
+
+	// Archive ids are remapped to the ids of the newly created folders
+	folderIds := map[int]int64{}
+	// A failed import removes everything it created, assets included
+	defer func() {
+		if err == nil {
+			return
+		}
+		if createdRoot, ok := folderIds[manifest.RootFolderId]; ok {
+			if _, _, cleanupErr := removeFolder(int(createdRoot)); cleanupErr != nil {
+				fmt.Println("[IMPORT] Failed to remove partial import", createdRoot, ":", cleanupErr)
+			}
+		}
+	}()
+	for i, folder := range manifest.Folders {
+		parentId := int64(targetFolderId)
+		if i > 0 {
+			var ok bool
+			if parentId, ok = folderIds[folder.ParentID]; !ok {
+				return 0, 0, fmt.Errorf("folder %d appears before its parent", folder.ID)
+			}
+		}
+		result, err := database.Db.Exec(`
+			INSERT INTO Folders (name, parent_folder_id, inventory_id)
+			SELECT ?, ?, inventory_id
+			FROM Folders
+			WHERE id = ?
+		`, folder.Name, parentId, parentId)
+		if err != nil {
+			return 0, 0, fmt.Errorf("failed to create folder %s: %w", folder.Name, err)
+		}
+		if folderIds[folder.ID], err = result.LastInsertId(); err != nil {
+			return 0, 0, err
+		}
+	}
+
+	for _, item := range manifest.Items {
+		folderId, ok := folderIds[item.FolderID]
+		if !ok {
+			return 0, 0, fmt.Errorf("item %s is in unknown folder %d", item.Name, item.FolderID)
+		}
+		recordEntry, ok := entries["records/"+item.Record+".brson"]
+		if !ok {
+			return 0, 0, fmt.Errorf("record of item %s is missing", item.Name)
+		}
+		record, err := readZipEntry(recordEntry)
+		if err != nil {
+			return 0, 0, err
+		}
+		// Records rewritten in place by migrate-asset-urls no longer hash to
+		// their name, so the record is stored under the hash of its contents
+		recordHash := hashAsset(record)
+		files := map[string][]byte{recordHash: record}
+		var linked []string
+		for _, hash := range item.Assets {
+			if isAssetStored(hash) {
+				linked = append(linked, hash)
+				continue
+			}
+			assetEntry, ok := entries["assets/"+hash]
+			if !ok {
+				return 0, 0, fmt.Errorf("asset %s of item %s is missing", hash, item.Name)
+			}
+			data, err := readZipEntry(assetEntry)
+			if err != nil {
+				return 0, 0, err
+			}
+			if hashAsset(data) != hash {
+				return 0, 0, fmt.Errorf("asset %s of item %s doesn't match its hash", hash, item.Name)
+			}
+			files[hash] = data
+		}
+		// The manifest only promises a hash, the thumbnail has to be an asset
+		// of the item or already stored
+		thumbnail := item.Thumbnail
+		if thumbnail != "" && !resolveThumbnail(thumbnail, files, &linked) {
+			fmt.Println("[IMPORT] Thumbnail", thumbnail, "of item", item.Name, "is missing, storing it without one")
+			thumbnail = ""
+		}
+		itemId, err := storeItem(int(folderId), item.Name, recordHash, thumbnail, files, linked)
+		if err != nil {
+			return 0, 0, err
+		}
+		for _, tag := range item.Tags {
+			if err := tagItem(itemId, tag); err != nil {
+				return 0, 0, fmt.Errorf("failed to tag item %s: %w", item.Name, err)
+			}
+		}
+	}
+	return folderIds[manifest.RootFolderId], len(manifest.Items), nil
+}
+
+// HandleImportArchive handles POST /import and recreates an inventory archive
+// below the folder given by folderId
+func HandleImportArchive(w http.ResponseWriter, r *http.Request) {
+	fmt.Println("[IMPORT] Import request received:", r.Method, r.URL.String())
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodPost {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": false,
+			"error":   "Invalid request method",
+		})
+		return
+	}
+	claims, err := authentication.ParseToken(authentication.RequestToken(r))
+	if err != nil {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": false,
+			"error":   "Auth token invalid or missing",
+		})
+		return
+	}
+	folderId, err := strconv.Atoi(r.URL.Query().Get("folderId"))
+	if err != nil {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": false,
+			"error":   "folderId missing or invalid",
+		})
+		return
+	}
+	if allowed, err := query.CheckFolderAccess(folderId, claims.UID, "editor"); err != nil || !allowed {
+		fmt.Println("[IMPORT] Access denied to folder ID:", folderId, "for user:", claims.Username)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": false,
+			"error":   "You don't have permission to import into this folder",
+		})
+		return
+	}
+
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": false,
+			"error":   "Failed to retrieve file: " + err.Error(),
+		})
+		return
+	}
+	defer file.Close()
+	zipReader, err := zip.NewReader(file, header.Size)
+	if err != nil {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": false,
+			"error":   "Failed to unzip file",
+		})
+		return
+	}
+
+	newFolderId, itemCount, err := importArchive(zipReader, folderId)
+	if err != nil {
+		fmt.Println("[IMPORT] Import into folder", folderId, "failed:", err.Error())
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": false,
+			"error":   "Failed to import archive: " + err.Error(),
+		})
+		return
+	}
+
+	fmt.Println("[IMPORT] Imported", itemCount, "items into new folder ID:", newFolderId)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":  true,
+		"folderId": newFolderId,
+		"items":    itemCount,
+	})
+}
@@ -0,0 +1,180 @@
+package upload
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"resonite-file-provider/authentication"
+	"resonite-file-provider/brson"
+	"resonite-file-provider/database"
+	"resonite-file-provider/query"
+	"resonite-file-provider/storage"
+	"strconv"
+	"strings"
+)
+
+// packageRecord is the R-Main.record document of a .resonitepackage
+type packageRecord struct {
+	ID           string   `json:"id"`
+	RecordType   string   `json:"recordType"`
+	Name         string   `json:"name"`
+	AssetURI     string   `json:"assetUri"`
+	ThumbnailURI string   `json:"thumbnailUri,omitempty"`
+	Tags         []string `json:"tags"`
+}
+
+// exportRecord returns an item's stored record with packdb:/// references.
+// Records are stored that way, except for ones uploaded by older versions
+// that migrate-asset-urls hasn't rewritten yet, whose baked-in asset URLs
+// are turned back into references here.
+func exportRecord(recordHash string) ([]byte, error) {
+	data, err := storage.ReadAll(storage.Assets, recordHash+".brson")
+	if err != nil {
+		return nil, err
+	}
+	doc, err := brson.Read(data)
+	if err != nil {
+		return nil, err
+	}
+	if brson.RewriteAssetUris(doc, LegacyAssetUrl()+"/", brson.AssetScheme) == 0 {
+		return data, nil
+	}
+	return brson.Write(doc)
+}
+
+// itemAssetHashes lists the hashes of every asset linked to an item
+func itemAssetHashes(itemId int) ([]string, error) {
+	rows, err := database.Db.Query("SELECT a.hash FROM Assets a INNER JOIN `hash-usage` hu ON hu.asset_id = a.id WHERE hu.item_id = ?", itemId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var hashes []string
+	for rows.Next() {
+		var hash string
+		if err := rows.Scan(&hash); err != nil {
+			return nil, err
+		}
+		hashes = append(hashes, hash)
+	}
+	return hashes, rows.Err()
+}
+
+// writeZipFile copies a stored object into a new zip entry
+func writeZipFile(zipWriter *zip.Writer, name string, object string) error {
+	file, err := storage.Assets.Get(object, 0, -1)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	entry, err := zipWriter.Create(name)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(entry, file)
+	return err
+}
+
+// HandleExportItem handles GET /export/item and streams the item back as a
+// .resonitepackage
+func HandleExportItem(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+	itemId, err := strconv.Atoi(r.URL.Query().Get("itemId"))
+	if err != nil {
+		http.Error(w, "itemId missing or invalid", http.StatusBadRequest)
+		return
+	}
+
+	claims, err := authentication.ParseToken(authentication.RequestToken(r))
+	if err != nil {
+		http.Error(w, "Auth token invalid or missing", http.StatusUnauthorized)
+		return
+	}
+
+	var itemName string
+	var folderId int
+	var recordHash string
+	var thumbnailHash string
+	err = database.Db.QueryRow("SELECT name, folder_id, url, COALESCE(thumbnail_url, '') FROM Items WHERE id = ?", itemId).Scan(&itemName, &folderId, &recordHash, &thumbnailHash)
+	if err != nil {
+		http.Error(w, "Item not found", http.StatusNotFound)
+		return
+	}
+	if allowed, err := query.CheckFolderAccess(folderId, claims.UID, "viewer"); err != nil || !allowed {
+		http.Error(w, "You don't have access to this item", http.StatusForbidden)
+		return
+	}
+
+	record, err := exportRecord(recordHash)
+	if err != nil {
+		http.Error(w, "Failed to read item record", http.StatusInternalServerError)
+		return
+	}
+	hashes, err := itemAssetHashes(itemId)
+	if err != nil {
+		http.Error(w, "Failed to list item assets", http.StatusInternalServerError)
+		return
+	}
+	tags, err := itemTagNames(itemId)
+	if err != nil {
+		http.Error(w, "Failed to list item tags", http.StatusInternalServerError)
+		return
+	}
+	mainRecord := packageRecord{
+		ID:         "R-Main",
+		RecordType: "object",
+		Name:       itemName,
+		AssetURI:   "packdb:///" + recordHash,
+		Tags:       tags,
+	}
+	if thumbnailHash != "" {
+		mainRecord.ThumbnailURI = "packdb:///" + thumbnailHash
+	}
+	mainRecordData, err := json.Marshal(mainRecord)
+	if err != nil {
+		http.Error(w, "Failed to encode main record", http.StatusInternalServerError)
+		return
+	}
+
+	// From here on the response is streamed. A failure aborts the connection
+	// without finishing the zip, so the client can't mistake the truncated
+	// package for a complete one.
+	fileName := strings.ReplaceAll(itemName, `"`, "") + ".resonitepackage"
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, fileName))
+
+	zipWriter := zip.NewWriter(w)
+	entry, err := zipWriter.Create("R-Main.record")
+	if err == nil {
+		_, err = entry.Write(mainRecordData)
+	}
+	if err == nil {
+		entry, err = zipWriter.Create("Assets/" + recordHash)
+	}
+	if err == nil {
+		_, err = entry.Write(record)
+	}
+	if err != nil {
+		fmt.Println("[EXPORT] Failed to write record of item", itemId, ":", err)
+		panic(http.ErrAbortHandler)
+	}
+	for _, hash := range hashes {
+		if hash == recordHash {
+			continue
+		}
+		if err := writeZipFile(zipWriter, "Assets/"+hash, hash); err != nil {
+			fmt.Println("[EXPORT] Failed to write asset", hash, "of item", itemId, ":", err)
+			panic(http.ErrAbortHandler)
+		}
+	}
+	if err := zipWriter.Close(); err != nil {
+		fmt.Println("[EXPORT] Failed to finish package of item", itemId, ":", err)
+		panic(http.ErrAbortHandler)
+	}
+}
@@ -0,0 +1,55 @@
+package upload
+
+import (
+	"bytes"
+	"reflect"
+	"resonite-file-provider/brson"
+	"resonite-file-provider/storage"
+	"testing"
+)
+
+func TestExportRecordRewritesLegacyUrls(t *testing.T) {
+	local, err := storage.NewLocal(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	storage.Assets = storage.NewSharded(local)
+
+	current, err := brson.Write(readRecordFixture(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	legacy := readRecordFixture(t)
+	brson.RewriteAssetUris(legacy, brson.AssetScheme, LegacyAssetUrl()+"/")
+	legacyRecord, err := brson.Write(legacy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	currentHash, legacyHash := hashAsset(current), hashAsset(legacyRecord)
+	for hash, data := range map[string][]byte{currentHash: current, legacyHash: legacyRecord} {
+		if err := storage.Assets.Put(hash+".brson", bytes.NewReader(data), int64(len(data))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	exported, err := exportRecord(currentHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(exported, current) {
+		t.Error("record without legacy URLs wasn't exported as stored")
+	}
+
+	exported, err = exportRecord(legacyHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc, err := brson.Read(exported)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]bool{fixtureMesh: true, fixtureTexture: true}
+	if refs := brson.AssetReferences(doc); !reflect.DeepEqual(refs, want) {
+		t.Errorf("references after export = %v, want %v", refs, want)
+	}
+}
@@ -0,0 +1,303 @@
+package upload
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"resonite-file-provider/authentication"
+	"resonite-file-provider/database"
+	"resonite-file-provider/query"
+	"resonite-file-provider/quota"
+	"strconv"
+	"strings"
+)
+
+// errFolderCycle is returned when a folder would be moved below itself
+var errFolderCycle = errors.New("can't move a folder into itself or one of its subfolders")
+
+// editableFolder reads the folder of a folder operation and checks that the
+// caller may edit it. It answers the request itself and returns ok false if
+// not.
+func editableFolder(w http.ResponseWriter, r *http.Request) (folderId int, userId int, isRoot bool, ok bool) {
+	if r.Method != http.MethodPost {
+		writeJSONError(w, "Invalid request method")
+		return 0, 0, false, false
+	}
+	claims, err := authentication.ParseToken(authentication.RequestToken(r))
+	if err != nil {
+		writeJSONError(w, "Auth token invalid or missing")
+		return 0, 0, false, false
+	}
+	folderId, err = strconv.Atoi(r.URL.Query().Get("folderId"))
+	if err != nil {
+		writeJSONError(w, "folderId missing or invalid")
+		return 0, 0, false, false
+	}
+	var parentId sql.NullInt64
+	if err := database.Db.QueryRow("SELECT parent_folder_id FROM Folders WHERE id = ?", folderId).Scan(&parentId); err != nil {
+		writeJSONError(w, "Folder not found")
+		return 0, 0, false, false
+	}
+	if allowed, err := query.CheckFolderAccess(folderId, claims.UID, "editor"); err != nil || !allowed {
+		fmt.Println("[FOLDER] Access denied to folder ID:", folderId, "for user:", claims.Username)
+		writeJSONError(w, "You don't have permission to edit this folder")
+		return 0, 0, false, false
+	}
+	return folderId, claims.UID, !parentId.Valid, true
+}
+
+// checkFolderCycle walks up from newParentId along parent_folder_id and
+// fails with errFolderCycle if it passes folderId, i.e. if the move would
+// detach the folder from its inventory's root
+func checkFolderCycle(q database.Queryer, folderId int, newParentId int) error {
+	seen := map[int]bool{}
+	for current := newParentId; ; {
+		if current == folderId {
+			return errFolderCycle
+		}
+		if seen[current] {
+			return fmt.Errorf("folder %d is part of a parent_folder_id cycle", current)
+		}
+		seen[current] = true
+
+		var parentId sql.NullInt64
+		if err := q.QueryRow("SELECT parent_folder_id FROM Folders WHERE id = ?", current).Scan(&parentId); err != nil {
+			return err
+		}
+		if !parentId.Valid {
+			return nil
+		}
+		current = int(parentId.Int64)
+	}
+}
+
+// subtreeAssetSizes returns the sizes of the assets used by items in the
+// given folders, keyed by hash
+func subtreeAssetSizes(q database.Queryer, folderIds []int) (map[string]int64, error) {
+	args := make([]interface{}, len(folderIds))
+	for i, id := range folderIds {
+		args[i] = id
+	}
+	rows, err := q.Query(`
+		SELECT DISTINCT a.hash, a.size
+		FROM Assets a
+		INNER JOIN `+"`hash-usage`"+` hu ON hu.asset_id = a.id
+		INNER JOIN Items it ON it.id = hu.item_id
+		WHERE it.folder_id IN (`+database.Placeholders(len(folderIds))+`)
+	`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sizes := make(map[string]int64)
+	for rows.Next() {
+		var hash string
+		var size int64
+		if err := rows.Scan(&hash, &size); err != nil {
+			return nil, err
+		}
+		sizes[hash] = size
+	}
+	return sizes, rows.Err()
+}
+
+// moveFolder moves a folder below newParentId. Moving it into another
+// inventory moves its whole subtree along, as long as the assets it uses fit
+// into the new inventory's quotas.
+func moveFolder(folderId int, newParentId int) error {
+	tx, err := database.Db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := quota.LockInventory(tx, newParentId); err != nil {
+		return err
+	}
+	if err := checkFolderCycle(tx, folderId, newParentId); err != nil {
+		return err
+	}
+	var inventoryId, newInventoryId int
+	if err := tx.QueryRow("SELECT inventory_id FROM Folders WHERE id = ?", folderId).Scan(&inventoryId); err != nil {
+		return err
+	}
+	if err := tx.QueryRow("SELECT inventory_id FROM Folders WHERE id = ?", newParentId).Scan(&newInventoryId); err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec("UPDATE Folders SET parent_folder_id = ? WHERE id = ?", newParentId, folderId); err != nil {
+		return err
+	}
+	// Within an inventory every asset is already accounted for
+	if inventoryId != newInventoryId {
+		folderIds, err := query.FolderSubtree(tx, folderId)
+		if err != nil {
+			return err
+		}
+		sizes, err := subtreeAssetSizes(tx, folderIds)
+		if err != nil {
+			return err
+		}
+		if err := quota.CheckUploadIn(tx, newParentId, sizes); err != nil {
+			return err
+		}
+		args := []interface{}{newInventoryId}
+		for _, id := range folderIds {
+			args = append(args, id)
+		}
+		if _, err := tx.Exec("UPDATE Folders SET inventory_id = ? WHERE id IN ("+database.Placeholders(len(folderIds))+")", args...); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// removeFolder deletes a folder with all of its subfolders and items in one
+// transaction. Assets are cleaned up like in removeItem. It returns the
+// number of folders and items deleted.
+func removeFolder(folderId int) (int, int, error) {
+	tx, err := database.Db.Begin()
+	if err != nil {
+		return 0, 0, err
+	}
+	defer tx.Rollback()
+
+	folderIds, err := query.FolderSubtree(tx, folderId)
+	if err != nil {
+		return 0, 0, err
+	}
+	var itemIds []int
+	for _, id := range folderIds {
+		rows, err := tx.Query("SELECT id FROM Items WHERE folder_id = ?", id)
+		if err != nil {
+			return 0, 0, err
+		}
+		for rows.Next() {
+			var itemId int
+			if err := rows.Scan(&itemId); err != nil {
+				rows.Close()
+				return 0, 0, err
+			}
+			itemIds = append(itemIds, itemId)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return 0, 0, err
+		}
+	}
+
+	var unusedHashes []string
+	for _, itemId := range itemIds {
+		hashes, err := removeItemTx(tx, itemId)
+		if err != nil {
+			return 0, 0, err
+		}
+		unusedHashes = append(unusedHashes, hashes...)
+	}
+	// Children before their parents
+	for i := len(folderIds) - 1; i >= 0; i-- {
+		if _, err := tx.Exec("DELETE FROM Folders WHERE id = ?", folderIds[i]); err != nil {
+			return 0, 0, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, 0, err
+	}
+
+	deleteStoredAssets(unusedHashes)
+	return len(folderIds), len(itemIds), nil
+}
+
+// HandleRenameFolder handles POST /renameFolder
+func HandleRenameFolder(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	folderId, _, _, ok := editableFolder(w, r)
+	if !ok {
+		return
+	}
+	name := strings.TrimSpace(r.URL.Query().Get("name"))
+	if name == "" {
+		writeJSONError(w, "name parameter is missing")
+		return
+	}
+
+	if _, err := database.Db.Exec("UPDATE Folders SET name = ? WHERE id = ?", name, folderId); err != nil {
+		fmt.Println("[FOLDER] Error renaming folder:", err.Error())
+		writeJSONError(w, "Failed to rename folder: "+err.Error())
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":  true,
+		"folderId": folderId,
+		"name":     name,
+	})
+}
+
+// HandleMoveFolder handles POST /moveFolder, which moves a folder with its
+// contents below another folder, possibly in another inventory
+func HandleMoveFolder(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	folderId, userId, isRoot, ok := editableFolder(w, r)
+	if !ok {
+		return
+	}
+	if isRoot {
+		writeJSONError(w, "The root folder of an inventory can't be moved")
+		return
+	}
+	parentId, ok := targetFolder(w, r, "parentId", userId)
+	if !ok {
+		return
+	}
+
+	if err := moveFolder(folderId, parentId); errors.Is(err, errFolderCycle) {
+		writeJSONError(w, "Can't move a folder into itself or one of its subfolders")
+		return
+	} else if errors.Is(err, quota.ErrExceeded) {
+		writeJSONError(w, err.Error())
+		return
+	} else if err != nil {
+		fmt.Println("[FOLDER] Error moving folder:", err.Error())
+		writeJSONError(w, "Failed to move folder: "+err.Error())
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":  true,
+		"folderId": folderId,
+		"parentId": parentId,
+	})
+}
+
+// HandleRemoveFolder handles POST /removeFolder, which deletes a folder with
+// everything in it
+func HandleRemoveFolder(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	folderId, _, isRoot, ok := editableFolder(w, r)
+	if !ok {
+		return
+	}
+	if isRoot {
+		writeJSONError(w, "The root folder of an inventory can't be deleted")
+		return
+	}
+
+	folders, items, err := removeFolder(folderId)
+	if err != nil {
+		fmt.Println("[FOLDER] Error removing folder:", err.Error())
+		writeJSONError(w, "Failed to remove folder: "+err.Error())
+		return
+	}
+	fmt.Println("[FOLDER] Removed folder ID:", folderId, "with", folders, "folders and", items, "items")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":  true,
+		"folderId": folderId,
+		"folders":  folders,
+		"items":    items,
+	})
+}
@@ -1,15 +1,14 @@
 package upload
 
 import (
+	"database/sql"
 	"encoding/json"
 	"fmt"
 	"net/http"
-	"os"
-	"path/filepath"
 	"resonite-file-provider/authentication"
-	"resonite-file-provider/config"
 	"resonite-file-provider/database"
 	"resonite-file-provider/query"
+	"resonite-file-provider/storage"
 	"strconv"
 )
 
@@ -349,18 +348,36 @@ func removeItem(itemId int) error {
 	}
 	defer tx.Rollback()
 	
+	unusedHashes, err := removeItemTx(tx, itemId)
+	if err != nil {
+		return err
+	}
+	
+	// Commit the transaction
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	
+	deleteStoredAssets(unusedHashes)
+	return nil
+}
+
+// removeItemTx deletes an item and the assets only it used within tx. It
+// returns the hashes of the deleted assets, whose files are to be removed
+// with deleteStoredAssets once tx is committed.
+func removeItemTx(tx *sql.Tx, itemId int) ([]string, error) {
 	// Get affected asset IDs
 	var affectedAssetIds []int
 	rows, err := tx.Query("SELECT asset_id FROM `hash-usage` WHERE item_id = ?", itemId)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer rows.Close()
 	
 	for rows.Next() {
 		var assetId int
 		if err := rows.Scan(&assetId); err != nil {
-			return err
+			return nil, err
 		}
 		affectedAssetIds = append(affectedAssetIds, assetId)
 	}
@@ -368,44 +385,60 @@ func removeItem(itemId int) error {
 	// Delete hash-usage entries for this item
 	_, err = tx.Exec("DELETE FROM `hash-usage` WHERE item_id = ?", itemId)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	
+	// Detach its tags
+	_, err = tx.Exec("DELETE FROM item_tags WHERE item_id = ?", itemId)
+	if err != nil {
+		return nil, err
 	}
 	
 	// Delete the item
 	_, err = tx.Exec("DELETE FROM Items WHERE id = ?", itemId)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	
 	// Check each affected asset to see if it's still used
+	var unusedHashes []string
 	for _, affectedId := range affectedAssetIds {
 		var assetHash string
 		err := tx.QueryRow("SELECT hash FROM Assets WHERE id = ?", affectedId).Scan(&assetHash)
 		if err != nil {
-			return err
+			return nil, err
 		}
 		
 		var count int
 		err = tx.QueryRow("SELECT COUNT(*) FROM `hash-usage` WHERE asset_id = ?", affectedId).Scan(&count)
 		if err != nil {
-			return err
+			return nil, err
 		}
 		
 		// If asset is no longer used, delete it
 		if count == 0 {
 			_, err := tx.Exec("DELETE FROM Assets WHERE id = ?", affectedId)
 			if err != nil {
-				return err
+				return nil, err
 			}
 			
-			// Delete the physical files
-			os.Remove(filepath.Join(config.GetConfig().Server.AssetsPath, assetHash))
-			os.Remove(filepath.Join(config.GetConfig().Server.AssetsPath, assetHash) + ".brson")
+			unusedHashes = append(unusedHashes, assetHash)
+		}
+	}
+	return unusedHashes, nil
+}
+
+// deleteStoredAssets deletes the stored files of assets once no row points at
+// them anymore. Files that fail to delete are left for the asset garbage
+// collector.
+func deleteStoredAssets(hashes []string) {
+	for _, assetHash := range hashes {
+		for _, name := range []string{assetHash, assetHash + ".brson"} {
+			if err := storage.Assets.Delete(name); err != nil && err != storage.ErrNotExist {
+				fmt.Println("[ITEM] Failed to delete stored file", name, ":", err.Error())
+			}
 		}
 	}
-	
-	// Commit the transaction
-	return tx.Commit()
 }
 
 func HandleRemoveItem(w http.ResponseWriter, r *http.Request){
@@ -0,0 +1,243 @@
+package upload
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"resonite-file-provider/authentication"
+	"resonite-file-provider/database"
+	"resonite-file-provider/query"
+	"resonite-file-provider/quota"
+	"strconv"
+	"strings"
+)
+
+// writeJSONError answers a failed item or folder operation
+func writeJSONError(w http.ResponseWriter, message string) {
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": false,
+		"error":   message,
+	})
+}
+
+// editableItem reads the item of an item operation and checks that the caller
+// may edit it. It answers the request itself and returns ok false if not.
+func editableItem(w http.ResponseWriter, r *http.Request) (itemId int, userId int, folderId int, ok bool) {
+	if r.Method != http.MethodPost {
+		writeJSONError(w, "Invalid request method")
+		return 0, 0, 0, false
+	}
+	claims, err := authentication.ParseToken(authentication.RequestToken(r))
+	if err != nil {
+		writeJSONError(w, "Auth token invalid or missing")
+		return 0, 0, 0, false
+	}
+	itemId, err = strconv.Atoi(r.URL.Query().Get("itemId"))
+	if err != nil {
+		writeJSONError(w, "itemId missing or invalid")
+		return 0, 0, 0, false
+	}
+	if err := database.Db.QueryRow("SELECT folder_id FROM Items WHERE id = ?", itemId).Scan(&folderId); err != nil {
+		writeJSONError(w, "Item not found")
+		return 0, 0, 0, false
+	}
+	if allowed, err := query.CheckFolderAccess(folderId, claims.UID, "editor"); err != nil || !allowed {
+		fmt.Println("[ITEM] Access denied to item ID:", itemId, "for user:", claims.Username)
+		writeJSONError(w, "You don't have permission to edit this item")
+		return 0, 0, 0, false
+	}
+	return itemId, claims.UID, folderId, true
+}
+
+// targetFolder reads the destination folder of a move or copy from param and
+// checks that the caller may edit it
+func targetFolder(w http.ResponseWriter, r *http.Request, param string, userId int) (int, bool) {
+	folderId, err := strconv.Atoi(r.URL.Query().Get(param))
+	if err != nil {
+		writeJSONError(w, param+" missing or invalid")
+		return 0, false
+	}
+	if allowed, err := query.CheckFolderAccess(folderId, userId, "editor"); err != nil || !allowed {
+		writeJSONError(w, "You don't have permission to edit the destination folder")
+		return 0, false
+	}
+	return folderId, true
+}
+
+// itemAssetSizes returns the sizes of the assets an item uses, keyed by hash
+func itemAssetSizes(q database.Queryer, itemId int) (map[string]int64, error) {
+	rows, err := q.Query("SELECT a.hash, a.size FROM Assets a INNER JOIN `hash-usage` hu ON hu.asset_id = a.id WHERE hu.item_id = ?", itemId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sizes := make(map[string]int64)
+	for rows.Next() {
+		var hash string
+		var size int64
+		if err := rows.Scan(&hash, &size); err != nil {
+			return nil, err
+		}
+		sizes[hash] = size
+	}
+	return sizes, rows.Err()
+}
+
+// checkItemQuota locks the folder's inventory for the rest of tx and
+// verifies that the assets of an item fit into its quotas. Assets the
+// inventory already uses are free, so moves and copies within an inventory
+// always pass.
+func checkItemQuota(tx *sql.Tx, itemId int, folderId int) error {
+	if err := quota.LockInventory(tx, folderId); err != nil {
+		return err
+	}
+	sizes, err := itemAssetSizes(tx, itemId)
+	if err != nil {
+		return err
+	}
+	return quota.CheckUploadIn(tx, folderId, sizes)
+}
+
+// moveItem moves an item into a folder if its assets fit into the quotas
+func moveItem(itemId int, folderId int) error {
+	tx, err := database.Db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := checkItemQuota(tx, itemId, folderId); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("UPDATE Items SET folder_id = ? WHERE id = ?", folderId, itemId); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// copyItem duplicates an item into a folder if its assets fit into the
+// quotas. The copy shares the record and assets of the original through new
+// hash-usage rows and has the same tags.
+func copyItem(itemId int, folderId int, name string) (int64, error) {
+	tx, err := database.Db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	if err := checkItemQuota(tx, itemId, folderId); err != nil {
+		return 0, err
+	}
+	result, err := tx.Exec("INSERT INTO Items (name, folder_id, url, thumbnail_url) SELECT ?, ?, url, thumbnail_url FROM Items WHERE id = ?", name, folderId, itemId)
+	if err != nil {
+		return 0, err
+	}
+	copyId, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+	if _, err := tx.Exec("INSERT INTO `hash-usage` (asset_id, item_id) SELECT asset_id, ? FROM `hash-usage` WHERE item_id = ?", copyId, itemId); err != nil {
+		return 0, err
+	}
+	if _, err := tx.Exec("INSERT INTO item_tags (tag_id, item_id) SELECT tag_id, ? FROM item_tags WHERE item_id = ?", copyId, itemId); err != nil {
+		return 0, err
+	}
+	return copyId, tx.Commit()
+}
+
+// HandleRenameItem handles POST /renameItem
+func HandleRenameItem(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	itemId, _, _, ok := editableItem(w, r)
+	if !ok {
+		return
+	}
+	name := strings.TrimSpace(r.URL.Query().Get("name"))
+	if name == "" {
+		writeJSONError(w, "name parameter is missing")
+		return
+	}
+
+	if _, err := database.Db.Exec("UPDATE Items SET name = ? WHERE id = ?", name, itemId); err != nil {
+		fmt.Println("[ITEM] Error renaming item:", err.Error())
+		writeJSONError(w, "Failed to rename item: "+err.Error())
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"itemId":  itemId,
+		"name":    name,
+	})
+}
+
+// HandleMoveItem handles POST /moveItem, which moves an item into another
+// folder, possibly in another inventory
+func HandleMoveItem(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	itemId, userId, _, ok := editableItem(w, r)
+	if !ok {
+		return
+	}
+	folderId, ok := targetFolder(w, r, "folderId", userId)
+	if !ok {
+		return
+	}
+
+	if err := moveItem(itemId, folderId); errors.Is(err, quota.ErrExceeded) {
+		writeJSONError(w, err.Error())
+		return
+	} else if err != nil {
+		fmt.Println("[ITEM] Error moving item:", err.Error())
+		writeJSONError(w, "Failed to move item: "+err.Error())
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":  true,
+		"itemId":   itemId,
+		"folderId": folderId,
+	})
+}
+
+// HandleCopyItem handles POST /copyItem, which duplicates an item into the
+// folder given by folderId, or next to the original if it is omitted
+func HandleCopyItem(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	itemId, userId, folderId, ok := editableItem(w, r)
+	if !ok {
+		return
+	}
+	if r.URL.Query().Get("folderId") != "" {
+		if folderId, ok = targetFolder(w, r, "folderId", userId); !ok {
+			return
+		}
+	}
+	name := strings.TrimSpace(r.URL.Query().Get("name"))
+	if name == "" {
+		if err := database.Db.QueryRow("SELECT name FROM Items WHERE id = ?", itemId).Scan(&name); err != nil {
+			writeJSONError(w, "Item not found")
+			return
+		}
+	}
+
+	copyId, err := copyItem(itemId, folderId, name)
+	if errors.Is(err, quota.ErrExceeded) {
+		writeJSONError(w, err.Error())
+		return
+	} else if err != nil {
+		fmt.Println("[ITEM] Error copying item:", err.Error())
+		writeJSONError(w, "Failed to copy item: "+err.Error())
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":  true,
+		"itemId":   copyId,
+		"folderId": folderId,
+		"name":     name,
+	})
+}
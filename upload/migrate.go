@@ -0,0 +1,70 @@
+package upload
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"resonite-file-provider/brson"
+	"resonite-file-provider/config"
+	"resonite-file-provider/database"
+	"resonite-file-provider/storage"
+	"strconv"
+)
+
+// LegacyAssetUrl is the prefix older versions of HandleUpload baked into
+// stored records in place of "packdb://"
+func LegacyAssetUrl() string {
+	return "https://" + filepath.Join(config.GetConfig().Server.Host+":"+strconv.Itoa(config.GetConfig().Server.Port), "assets")
+}
+
+// MigrateRecordUrls turns asset URLs starting with prefix back into the
+// packdb:// references records are now stored with. It returns the number of
+// records that were (or, for a dry run, would be) rewritten.
+func MigrateRecordUrls(prefix string, dryRun bool) (int, error) {
+	rows, err := database.Db.Query("SELECT DISTINCT url FROM Items")
+	if err != nil {
+		return 0, err
+	}
+	var hashes []string
+	for rows.Next() {
+		var hash string
+		if err := rows.Scan(&hash); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		hashes = append(hashes, hash)
+	}
+	rows.Close()
+
+	migrated := 0
+	for _, hash := range hashes {
+		recordName := hash + ".brson"
+		data, err := storage.ReadAll(storage.Assets, recordName)
+		if err != nil {
+			fmt.Println("[MIGRATE] Skipping unreadable record", hash, ":", err)
+			continue
+		}
+		doc, err := brson.Read(data)
+		if err != nil {
+			fmt.Println("[MIGRATE] Skipping invalid record", hash, ":", err)
+			continue
+		}
+		if brson.RewriteAssetUris(doc, prefix, "packdb://") == 0 {
+			continue
+		}
+		migrated++
+		if dryRun {
+			fmt.Println("[MIGRATE] Would rewrite record", hash)
+			continue
+		}
+		record, err := brson.Write(doc)
+		if err != nil {
+			return migrated, fmt.Errorf("failed to encode record %s: %w", hash, err)
+		}
+		if err := storage.Assets.Put(recordName, bytes.NewReader(record), int64(len(record))); err != nil {
+			return migrated, fmt.Errorf("failed to write record %s: %w", hash, err)
+		}
+		fmt.Println("[MIGRATE] Rewrote record", hash)
+	}
+	return migrated, nil
+}
@@ -0,0 +1,244 @@
+package upload
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"resonite-file-provider/authentication"
+	"resonite-file-provider/brson"
+	"resonite-file-provider/database"
+	"resonite-file-provider/query"
+	"resonite-file-provider/quota"
+	"resonite-file-provider/storage"
+	"strconv"
+)
+
+// maxRecordJSONSize limits the size of an edited record document
+const maxRecordJSONSize = 64 << 20
+
+// errInvalidRecord marks documents that can't replace an item's record
+var errInvalidRecord = errors.New("invalid record")
+
+// RecordJSON decodes an item's stored record to indented extended JSON,
+// optionally only the value at path (see brson.Select)
+func RecordJSON(itemId int, path string) ([]byte, error) {
+	var recordHash string
+	if err := database.Db.QueryRow("SELECT url FROM Items WHERE id = ?", itemId).Scan(&recordHash); err != nil {
+		return nil, err
+	}
+	data, err := exportRecord(recordHash)
+	if err != nil {
+		return nil, err
+	}
+	doc, err := brson.Read(data)
+	if err != nil {
+		return nil, err
+	}
+	value, err := brson.Select(doc, path)
+	if err != nil {
+		return nil, err
+	}
+	return brson.ToJSON(value)
+}
+
+// encodeEditedRecord encodes a record document as returned by RecordJSON as
+// brson and returns it with the hashes of the assets it references
+func encodeEditedRecord(data []byte) ([]byte, map[string]bool, error) {
+	doc, err := brson.FromJSON(data)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %v", errInvalidRecord, err)
+	}
+	if _, ok := doc["Object"].(map[string]interface{}); !ok {
+		return nil, nil, fmt.Errorf("%w: document has no Object slot", errInvalidRecord)
+	}
+	record, err := brson.Write(doc)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %v", errInvalidRecord, err)
+	}
+	if _, err := brson.Read(record); err != nil {
+		return nil, nil, fmt.Errorf("%w: %v", errInvalidRecord, err)
+	}
+	return record, brson.AssetReferences(doc), nil
+}
+
+// ReplaceRecord encodes an edited JSON document as brson and makes it the
+// item's record. The document must be a record whose asset references are
+// all stored already. The new record and its references are linked to the
+// item and the replaced record is unlinked. Other links are kept: the walk
+// doesn't see every asset a record uses, so a reference missing from the
+// edit doesn't prove the asset unused. It returns the hash of the new record.
+func ReplaceRecord(itemId int, data []byte) (string, error) {
+	record, refs, err := encodeEditedRecord(data)
+	if err != nil {
+		return "", err
+	}
+	for hash := range refs {
+		if !isAssetStored(hash) {
+			return "", fmt.Errorf("%w: references missing asset %s", errInvalidRecord, hash)
+		}
+	}
+
+	var folderId int
+	var thumbnailHash string
+	err = database.Db.QueryRow("SELECT folder_id, COALESCE(thumbnail_url, '') FROM Items WHERE id = ?", itemId).Scan(&folderId, &thumbnailHash)
+	if err != nil {
+		return "", err
+	}
+	recordHash := hashAsset(record)
+	sizes := map[string]int64{recordHash: int64(len(record))}
+	// Refuse early before writing anything, the check that counts is repeated
+	// under lock below
+	if err := quota.CheckUpload(folderId, sizes); err != nil {
+		return "", err
+	}
+	if err := storage.Assets.Put(recordHash+".brson", bytes.NewReader(record), int64(len(record))); err != nil {
+		return "", fmt.Errorf("failed to write record: %w", err)
+	}
+
+	assetIds := make(map[int64]bool)
+	recordAssetId, err := assetIdForHash(recordHash, record)
+	if err != nil {
+		return "", fmt.Errorf("failed to register record: %w", err)
+	}
+	assetIds[recordAssetId] = true
+	if thumbnailHash != "" {
+		refs[thumbnailHash] = true
+	}
+	for hash := range refs {
+		assetId, err := assetIdForHash(hash, nil)
+		if err != nil {
+			return "", fmt.Errorf("failed to register asset %s: %w", hash, err)
+		}
+		assetIds[assetId] = true
+	}
+
+	tx, err := database.Db.Begin()
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+	if err := quota.LockInventory(tx, folderId); err != nil {
+		return "", err
+	}
+	var oldRecordHash string
+	var currentFolderId int
+	if err := tx.QueryRow("SELECT url, folder_id FROM Items WHERE id = ? FOR UPDATE", itemId).Scan(&oldRecordHash, &currentFolderId); err != nil {
+		return "", err
+	}
+	if currentFolderId != folderId {
+		return "", errors.New("the item was moved while its record was being replaced")
+	}
+	if err := quota.CheckUploadIn(tx, folderId, sizes); err != nil {
+		return "", err
+	}
+	if _, err := tx.Exec("UPDATE Items SET url = ? WHERE id = ?", recordHash, itemId); err != nil {
+		return "", err
+	}
+	if oldRecordHash != recordHash {
+		if _, err := tx.Exec("DELETE hu FROM `hash-usage` hu INNER JOIN `Assets` a ON a.id = hu.asset_id WHERE hu.item_id = ? AND a.hash = ?", itemId, oldRecordHash); err != nil {
+			return "", err
+		}
+	}
+	for assetId := range assetIds {
+		_, err := tx.Exec(`
+			INSERT INTO `+"`hash-usage`"+` (asset_id, item_id)
+			SELECT ?, ? FROM DUAL
+			WHERE NOT EXISTS (SELECT 1 FROM `+"`hash-usage`"+` WHERE asset_id = ? AND item_id = ?)
+		`, assetId, itemId, assetId, itemId)
+		if err != nil {
+			return "", err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return "", err
+	}
+	return recordHash, nil
+}
+
+// HandleItemRecord handles /item/record. GET returns the item's record as
+// JSON, optionally only the value at the path query parameter. PUT replaces
+// the record with the JSON document in the request body.
+func HandleItemRecord(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPut {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+	itemId, err := strconv.Atoi(r.URL.Query().Get("itemId"))
+	if err != nil {
+		http.Error(w, "itemId missing or invalid", http.StatusBadRequest)
+		return
+	}
+
+	claims, err := authentication.ParseToken(authentication.RequestToken(r))
+	if err != nil {
+		http.Error(w, "Auth token invalid or missing", http.StatusUnauthorized)
+		return
+	}
+	var folderId int
+	if err := database.Db.QueryRow("SELECT folder_id FROM Items WHERE id = ?", itemId).Scan(&folderId); err != nil {
+		http.Error(w, "Item not found", http.StatusNotFound)
+		return
+	}
+
+	if r.Method == http.MethodGet {
+		if allowed, err := query.CheckFolderAccess(folderId, claims.UID, "viewer"); err != nil || !allowed {
+			http.Error(w, "You don't have access to this item", http.StatusForbidden)
+			return
+		}
+		data, err := RecordJSON(itemId, r.URL.Query().Get("path"))
+		if errors.Is(err, brson.ErrNoValue) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		} else if err != nil {
+			fmt.Println("[RECORD] Failed to decode record of item", itemId, ":", err)
+			http.Error(w, "Failed to decode record: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(data)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if allowed, err := query.CheckFolderAccess(folderId, claims.UID, "editor"); err != nil || !allowed {
+		w.WriteHeader(http.StatusForbidden)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": false,
+			"error":   "You don't have permission to edit this item",
+		})
+		return
+	}
+	data, err := io.ReadAll(io.LimitReader(r.Body, maxRecordJSONSize))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": false,
+			"error":   "Failed to read request body",
+		})
+		return
+	}
+	recordHash, err := ReplaceRecord(itemId, data)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, errInvalidRecord) {
+			status = http.StatusBadRequest
+		} else if errors.Is(err, quota.ErrExceeded) {
+			status = http.StatusRequestEntityTooLarge
+		}
+		fmt.Println("[RECORD] Failed to replace record of item", itemId, ":", err)
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+	fmt.Println("[RECORD] Replaced record of item", itemId, "with", recordHash)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"record":  recordHash,
+	})
+}
@@ -0,0 +1,45 @@
+package upload
+
+import (
+	"bytes"
+	"reflect"
+	"resonite-file-provider/brson"
+	"resonite-file-provider/storage"
+	"testing"
+)
+
+// TestRecordJSONRoundTrip sends a record through the JSON RecordJSON returns
+// and back, as a client editing it with GET and PUT /item/record does
+func TestRecordJSONRoundTrip(t *testing.T) {
+	doc := readRecordFixture(t)
+	stored, err := brson.Write(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := brson.ToJSON(doc)
+	if err != nil {
+		t.Fatalf("ToJSON: %v", err)
+	}
+
+	record, refs, err := encodeEditedRecord(data)
+	if err != nil {
+		t.Fatalf("encodeEditedRecord: %v", err)
+	}
+	if !bytes.Equal(record, stored) {
+		t.Error("unchanged record doesn't encode to the stored bytes")
+	}
+	// References have to name stored objects for the PUT to accept them
+	want := map[string]bool{fixtureMesh: true, fixtureTexture: true}
+	if !reflect.DeepEqual(refs, want) {
+		t.Errorf("references = %v, want %v", refs, want)
+	}
+	for hash := range refs {
+		if !storage.IsHash(hash) {
+			t.Errorf("reference %q isn't a storage name", hash)
+		}
+	}
+
+	if _, _, err := encodeEditedRecord([]byte(`{"Assets": []}`)); err == nil {
+		t.Error("encodeEditedRecord accepted a document without Object")
+	}
+}
@@ -0,0 +1,191 @@
+package upload
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"path/filepath"
+	"resonite-file-provider/authentication"
+	"resonite-file-provider/brson"
+	"resonite-file-provider/database"
+	"resonite-file-provider/query"
+	"resonite-file-provider/quota"
+	"resonite-file-provider/storage"
+	"strconv"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// maxRecordUploadMemory is how much of a record upload is kept in memory
+// before multipart parts are spilled to temporary files
+const maxRecordUploadMemory = 32 << 20
+
+// hashAsset returns the content hash Resonite uses as an asset's name
+func hashAsset(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// isAssetStored reports whether an asset is registered and its file exists.
+// hash may also be a reference with an extension, which Assets rows and
+// storage names never carry. Records are stored with a .brson suffix, so
+// both names are checked.
+func isAssetStored(hash string) bool {
+	hash = brson.AssetHash(hash)
+	var exists bool
+	err := database.Db.QueryRow("SELECT EXISTS(SELECT 1 FROM `Assets` WHERE `hash` = ?)", hash).Scan(&exists)
+	if err != nil || !exists {
+		return false
+	}
+	for _, name := range []string{hash, hash + storage.RecordSuffix} {
+		if _, err := storage.Assets.Stat(name); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// decodeRecordDocument accepts a record as brson or as (extended) JSON and
+// returns it as brson along with the decoded document
+func decodeRecordDocument(data []byte) ([]byte, map[string]interface{}, error) {
+	if bytes.HasPrefix(data, brson.Header) {
+		doc, err := brson.Read(data)
+		if err != nil {
+			return nil, nil, err
+		}
+		return data, doc, nil
+	}
+	var doc map[string]interface{}
+	if err := bson.UnmarshalExtJSON(data, false, &doc); err != nil {
+		return nil, nil, fmt.Errorf("record is neither brson nor JSON: %w", err)
+	}
+	encoded, err := brson.Write(doc)
+	if err != nil {
+		return nil, nil, err
+	}
+	return encoded, doc, nil
+}
+
+// recordName returns the name of the root slot of a record document
+func recordName(doc map[string]interface{}) string {
+	object, ok := doc["Object"].(map[string]interface{})
+	if !ok {
+		return ""
+	}
+	name, ok := object["Name"].(map[string]interface{})
+	if !ok {
+		return ""
+	}
+	data, _ := name["Data"].(string)
+	return data
+}
+
+func readPart(fileHeader *multipart.FileHeader) ([]byte, error) {
+	file, err := fileHeader.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return io.ReadAll(file)
+}
+
+// HandleUploadRecord creates an item from a bare record document and its
+// loose assets instead of a .resonitepackage.
+//
+// Form data:
+//   - record: the record document, as brson or JSON
+//   - asset: asset files named by their hash (repeatable)
+//   - name: optional item name, defaults to the record's root slot name
+func HandleUploadRecord(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+	folderId, err := strconv.Atoi(r.URL.Query().Get("folderId"))
+	if err != nil {
+		http.Error(w, "folderId missing or invalid", http.StatusBadRequest)
+		return
+	}
+
+	claims, err := authentication.ParseToken(authentication.RequestToken(r))
+	if err != nil {
+		http.Error(w, "Auth token invalid or missing", http.StatusUnauthorized)
+		return
+	}
+	if allowed, err := query.CheckFolderAccess(folderId, claims.UID, "editor"); err != nil || !allowed {
+		http.Error(w, "You don't have permission to upload to this folder", http.StatusForbidden)
+		return
+	}
+
+	if err := r.ParseMultipartForm(maxRecordUploadMemory); err != nil {
+		http.Error(w, "Failed to parse form: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer r.MultipartForm.RemoveAll()
+
+	recordParts := r.MultipartForm.File["record"]
+	if len(recordParts) != 1 {
+		http.Error(w, "Exactly one record must be provided", http.StatusBadRequest)
+		return
+	}
+	recordData, err := readPart(recordParts[0])
+	if err != nil {
+		http.Error(w, "Failed to read record", http.StatusInternalServerError)
+		return
+	}
+	record, doc, err := decodeRecordDocument(recordData)
+	if err != nil {
+		http.Error(w, "Invalid record: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	itemName := r.FormValue("name")
+	if itemName == "" {
+		itemName = recordName(doc)
+	}
+	if itemName == "" {
+		http.Error(w, "Item name missing and record has no name", http.StatusBadRequest)
+		return
+	}
+
+	recordHash := hashAsset(record)
+	files := map[string][]byte{recordHash: record}
+	for _, assetPart := range r.MultipartForm.File["asset"] {
+		data, err := readPart(assetPart)
+		if err != nil {
+			http.Error(w, "Failed to read asset "+assetPart.Filename, http.StatusInternalServerError)
+			return
+		}
+		hash := filepath.Base(assetPart.Filename)
+		if hashAsset(data) != hash {
+			http.Error(w, "Asset "+assetPart.Filename+" does not match its hash", http.StatusBadRequest)
+			return
+		}
+		files[hash] = data
+	}
+
+	var linked []string
+	for _, hash := range referencesOutside(doc, files) {
+		if !isAssetStored(hash) {
+			http.Error(w, "Record references missing asset "+hash, http.StatusBadRequest)
+			return
+		}
+		linked = append(linked, hash)
+	}
+
+	itemId, err := storeItem(folderId, itemName, recordHash, "", files, linked)
+	if errors.Is(err, quota.ErrExceeded) {
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	} else if err != nil {
+		http.Error(w, "Failed to store item: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	fmt.Println("[UPLOAD] Stored record", recordHash, "as item", itemId)
+	w.Write([]byte("Record uploaded successfully"))
+}
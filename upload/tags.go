@@ -0,0 +1,155 @@
+package upload
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"resonite-file-provider/authentication"
+	"resonite-file-provider/database"
+	"resonite-file-provider/query"
+	"strconv"
+	"strings"
+)
+
+// maxTagLength is the longest tag name accepted, in bytes
+const maxTagLength = 255
+
+var errInvalidTag = errors.New("tag must not be empty or longer than 255 bytes")
+
+// cleanTagName trims a tag name and checks that it can be stored
+func cleanTagName(name string) (string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" || len(name) > maxTagLength {
+		return "", errInvalidTag
+	}
+	return name, nil
+}
+
+// recordTags returns the tags listed in the tags field of a package's main
+// record, skipping entries that aren't valid tag names
+func recordTags(recordData map[string]any) []string {
+	entries, _ := recordData["tags"].([]any)
+	var tags []string
+	for _, entry := range entries {
+		name, ok := entry.(string)
+		if !ok {
+			continue
+		}
+		if name, err := cleanTagName(name); err == nil {
+			tags = append(tags, name)
+		}
+	}
+	return tags
+}
+
+// itemTagNames lists the names of the tags attached to an item
+func itemTagNames(itemId int) ([]string, error) {
+	rows, err := database.Db.Query("SELECT t.name FROM Tags t INNER JOIN item_tags it ON it.tag_id = t.id WHERE it.item_id = ? ORDER BY t.name", itemId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tags := []string{}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		tags = append(tags, name)
+	}
+	return tags, rows.Err()
+}
+
+// tagItem attaches the tag with the given name to an item, creating the tag
+// if it doesn't exist yet. Tagging an item twice with the same tag is a no-op.
+func tagItem(itemId int64, name string) error {
+	// On a duplicate name LAST_INSERT_ID(id) hands back the existing tag
+	result, err := database.Db.Exec("INSERT INTO Tags (name) VALUES (?) ON DUPLICATE KEY UPDATE id = LAST_INSERT_ID(id)", name)
+	if err != nil {
+		return err
+	}
+	tagId, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+	_, err = database.Db.Exec(`
+		INSERT INTO item_tags (tag_id, item_id)
+		SELECT ?, ? FROM DUAL
+		WHERE NOT EXISTS (SELECT 1 FROM item_tags WHERE tag_id = ? AND item_id = ?)
+	`, tagId, itemId, tagId, itemId)
+	return err
+}
+
+// untagItem detaches the tag with the given name from an item. The tag
+// itself is kept, tag listings only count tags that are in use.
+func untagItem(itemId int64, name string) error {
+	_, err := database.Db.Exec("DELETE FROM item_tags WHERE item_id = ? AND tag_id IN (SELECT id FROM Tags WHERE name = ?)", itemId, name)
+	return err
+}
+
+// HandleItemTags handles /item/tags. GET lists an item's tags, POST adds the
+// tag given by tag and DELETE removes it.
+func HandleItemTags(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost && r.Method != http.MethodDelete {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+	itemId, err := strconv.Atoi(r.URL.Query().Get("itemId"))
+	if err != nil {
+		http.Error(w, "itemId missing or invalid", http.StatusBadRequest)
+		return
+	}
+
+	claims, err := authentication.ParseToken(authentication.RequestToken(r))
+	if err != nil {
+		http.Error(w, "Auth token invalid or missing", http.StatusUnauthorized)
+		return
+	}
+	var folderId int
+	if err := database.Db.QueryRow("SELECT folder_id FROM Items WHERE id = ?", itemId).Scan(&folderId); err != nil {
+		http.Error(w, "Item not found", http.StatusNotFound)
+		return
+	}
+
+	if r.Method == http.MethodGet {
+		if allowed, err := query.CheckFolderAccess(folderId, claims.UID, "viewer"); err != nil || !allowed {
+			http.Error(w, "You don't have access to this item", http.StatusForbidden)
+			return
+		}
+	} else if allowed, err := query.CheckFolderAccess(folderId, claims.UID, "editor"); err != nil || !allowed {
+		http.Error(w, "You don't have permission to edit this item", http.StatusForbidden)
+		return
+	}
+
+	if r.Method != http.MethodGet {
+		tag, err := cleanTagName(r.URL.Query().Get("tag"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if r.Method == http.MethodPost {
+			err = tagItem(int64(itemId), tag)
+		} else {
+			err = untagItem(int64(itemId), tag)
+		}
+		if err != nil {
+			fmt.Println("[TAGS] Failed to update tags of item", itemId, ":", err)
+			http.Error(w, "Failed to update tags", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	tags, err := itemTagNames(itemId)
+	if err != nil {
+		http.Error(w, "Failed to list tags", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"itemId":  itemId,
+		"tags":    tags,
+	})
+}
@@ -3,84 +3,23 @@ package upload
 import (
 	"archive/zip"
 	"bytes"
+	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
-	"os"
 	"path/filepath"
-	"resonite-file-provider/config"
+	"resonite-file-provider/assetinfo"
+	"resonite-file-provider/brson"
 	"resonite-file-provider/database"
+	"resonite-file-provider/quota"
+	"resonite-file-provider/storage"
+	"sort"
 	"strconv"
 	"strings"
 
-	"github.com/andybalholm/brotli"
-	"go.mongodb.org/mongo-driver/bson"
-	"go.mongodb.org/mongo-driver/bson/primitive"
 )
-var brsonHeader = []byte{70, 114, 68, 84, 0, 0, 0, 0, 3}
-
-func mapRecursiveReplace(data interface{}, old string, new string) interface{} {
-	switch v := data.(type) {
-	case map[string]interface{}:
-		for key, value := range v {
-			v[key] = mapRecursiveReplace(value, old, new)
-		}
-		return v
-	case []interface{}:
-		for i, item := range v {
-			v[i] = mapRecursiveReplace(item, old, new)
-		}
-		return v
-	case primitive.A:
-		for i, item := range v {
-			v[i] = mapRecursiveReplace(item, old, new)
-		}
-		return v
-	case string:
-		return strings.ReplaceAll(v, old, new)
-	default:
-		return v
-	}
-}
-
-func writeBrson(doc map[string]interface{}) ([]byte, error) {
-	bsonData, err := bson.Marshal(doc)
-	if err != nil {
-		return nil, fmt.Errorf("failed to marshal BSON: %w", err)
-	}
-
-	var compressedBuf bytes.Buffer
-	writer := brotli.NewWriter(&compressedBuf)
-	if _, err := writer.Write(bsonData); err != nil {
-		return nil, fmt.Errorf("brotli compression failed: %w", err)
-	}
-	writer.Close()
-
-	final := append(brsonHeader, compressedBuf.Bytes()...)
-	return final, nil
-}
-
-func readBrson(data []byte) (map[string]any, error) {
-	if !bytes.Equal(data[:9], brsonHeader) {
-		return nil, fmt.Errorf("invalid BRSON header")
-	}
-	// BRSON header is skipped
-	compressed := data[9:]
-
-	br := brotli.NewReader(bytes.NewReader(compressed))
-	decompressed, err := io.ReadAll(br)
-	if err != nil {
-		return nil, fmt.Errorf("brotli decompression failed: %w", err)
-	}
-
-	var doc map[string]any
-	if err := bson.Unmarshal(decompressed, &doc); err != nil {
-		return nil, fmt.Errorf("bson unmarshal failed: %w", err)
-	}
-
-	return doc, nil
-}
 
 func HandleUpload(w http.ResponseWriter, r *http.Request) {
 	folderId, err := strconv.Atoi(r.URL.Query().Get("folderId"))
@@ -118,6 +57,8 @@ func HandleUpload(w http.ResponseWriter, r *http.Request) {
 	}
 	var assetFilename string
 	var itemName string
+	var thumbnailUri string
+	var tags []string
 	// first read asset record
 	for _, f := range zipReader.File {
 		file, err := f.Open()
@@ -138,6 +79,8 @@ func HandleUpload(w http.ResponseWriter, r *http.Request) {
 			}
 			assetFilename = strings.TrimPrefix(recordData["assetUri"].(string), "packdb:///")
 			itemName = recordData["name"].(string)
+			thumbnailUri, _ = recordData["thumbnailUri"].(string)
+			tags = recordTags(recordData)
 			if assetFilename == "" || itemName == "" {
 				http.Error(w, "Failed to read file, invalid main record ", http.StatusBadRequest)
 				return
@@ -145,75 +88,244 @@ func HandleUpload(w http.ResponseWriter, r *http.Request) {
 			break
 		}
 	}
-	itemInsertResult, err := database.Db.Exec("INSERT INTO `Items` (`name`, `folder_id`, `url`) VALUES (?, ?, ?)", itemName, folderId, assetFilename)
-	if err != nil {
-		http.Error(w, "Failed to insert item into database", http.StatusInternalServerError)
-		return
-	}
-	itemId, err := itemInsertResult.LastInsertId()
-	if err != nil {
-		http.Error(w, "Failed to get last insert id", http.StatusInternalServerError)
+	if assetFilename == "" || itemName == "" {
+		http.Error(w, "Failed to read file, invalid main record ", http.StatusBadRequest)
 		return
 	}
+	files := make(map[string][]byte)
 	for _, f := range zipReader.File {
+		if filepath.Dir(f.Name) != "Assets" {
+			continue
+		}
+		if !storage.IsHash(filepath.Base(f.Name)) {
+			http.Error(w, "Package asset "+filepath.Base(f.Name)+" is not named by its hash", http.StatusBadRequest)
+			return
+		}
 		file, err := f.Open()
 		if err != nil {
 			http.Error(w, "Failed to read file: ", http.StatusInternalServerError)
 			return
 		}
 		data, err := io.ReadAll(file)
+		file.Close()
 		if err != nil {
 			http.Error(w, "Failed to read file: ", http.StatusInternalServerError)
 			return
 		}
-		defer file.Close()
-		if filepath.Dir(f.Name) == "Assets"  {
-			filedir := filepath.Join(config.GetConfig().Server.AssetsPath, filepath.Base(f.Name))
-			if filepath.Base(f.Name) != assetFilename {
-				err = os.WriteFile(filedir, data, 0644)
-			} else {
-				err = os.WriteFile(filedir + ".brson", data, 0644)
-			}
-			if err != nil {
-				http.Error(w, "Failed to write file: ", http.StatusInternalServerError)
-				return
-			}
-			assetInsertResult, err := database.Db.Exec("INSERT INTO `Assets` (`hash`) VALUES (?)", filepath.Base(f.Name))
-			if err == nil {
-				assetId, err := assetInsertResult.LastInsertId()
-				if err != nil {
-					http.Error(w, "Failed to get last insert id", http.StatusInternalServerError)
-					return
-				}
-				database.Db.Exec("INSERT INTO `hash-usage` (`asset_id`, `item_id`) VALUES (?, ?)", assetId, itemId)
-			}
-		}
-		
+		files[filepath.Base(f.Name)] = data
 	}
-	if assetFilename == "" || itemName == "" {
-		http.Error(w, "Failed to read file, invalid main record ", http.StatusBadRequest)
+	if _, ok := files[assetFilename]; !ok {
+		http.Error(w, "Failed to read file, main record asset is missing", http.StatusBadRequest)
 		return
 	}
-	brson, err := os.ReadFile(filepath.Join(config.GetConfig().Server.AssetsPath, assetFilename + ".brson"))
+	record, err := brson.Read(files[assetFilename])
 	if err != nil {
-		http.Error(w, "Failed to read file", http.StatusInternalServerError)
+		http.Error(w, "Failed to read file, invalid main record ", http.StatusBadRequest)
 		return
 	}
-	brsonData, err := readBrson(brson)
-	if err != nil {
-		http.Error(w, "Failed to read file: ", http.StatusInternalServerError)
+	// Cross-check the record's references against the package contents
+	var linked []string
+	for _, hash := range referencesOutside(record, files) {
+		if !isAssetStored(hash) {
+			http.Error(w, "Package is missing referenced asset "+hash, http.StatusBadRequest)
+			return
+		}
+		linked = append(linked, hash)
+	}
+	thumbnailHash := packageThumbnail(thumbnailUri, files, &linked)
+	itemId, err := storeItem(folderId, itemName, assetFilename, thumbnailHash, files, linked)
+	if errors.Is(err, quota.ErrExceeded) {
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
 		return
+	} else if err != nil {
+		http.Error(w, "Failed to store item: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	// The item is stored at this point, a missing tag doesn't fail the upload
+	for _, tag := range tags {
+		if err := tagItem(itemId, tag); err != nil {
+			fmt.Println("[TAGS] Failed to tag item", itemId, "with", tag, ":", err)
+		}
 	}
-	assetUrl := "https://" + filepath.Join(config.GetConfig().Server.Host + ":" + strconv.Itoa(config.GetConfig().Server.Port), "assets")
-	newBrsonData := mapRecursiveReplace(brsonData, "packdb://", assetUrl)
-	newBrson, err := writeBrson(newBrsonData.(map[string]interface{}))
-	os.WriteFile(filepath.Join(config.GetConfig().Server.AssetsPath, assetFilename) + ".brson", newBrson, 0644)
 	w.Write([]byte("File uploaded successfully"))
 }
 
+// referencesOutside returns the hashes of the assets a record references
+// that aren't in files, sorted. Those have to be stored already.
+func referencesOutside(record map[string]interface{}, files map[string][]byte) []string {
+	var hashes []string
+	for hash := range brson.AssetReferences(record) {
+		if _, ok := files[hash]; !ok {
+			hashes = append(hashes, hash)
+		}
+	}
+	sort.Strings(hashes)
+	return hashes
+}
+
+// packageThumbnail resolves a record's thumbnailUri to the hash of an asset
+// in the package or in storage, adding the latter to linked. Thumbnails that
+// can't be found are dropped rather than failing the upload.
+func packageThumbnail(thumbnailUri string, files map[string][]byte, linked *[]string) string {
+	if !strings.HasPrefix(thumbnailUri, brson.AssetScheme) {
+		return ""
+	}
+	hash := brson.AssetHash(thumbnailUri)
+	if !resolveThumbnail(hash, files, linked) {
+		fmt.Println("[UPLOAD] Thumbnail", hash, "is missing, storing item without it")
+		return ""
+	}
+	return hash
+}
+
+// resolveThumbnail reports whether hash names an asset in files, in linked or
+// in storage, adding the latter to linked so the item keeps it alive
+func resolveThumbnail(hash string, files map[string][]byte, linked *[]string) bool {
+	if _, ok := files[hash]; ok {
+		return true
+	}
+	for _, linkedHash := range *linked {
+		if linkedHash == hash {
+			return true
+		}
+	}
+	if !isAssetStored(hash) {
+		return false
+	}
+	*linked = append(*linked, hash)
+	return true
+}
+
+// assetIdForHash returns the id of the Assets row for hash, inserting it if
+// the asset has not been stored before. data holds the asset's contents when
+// they were uploaded and is nil for assets that are only linked.
+func assetIdForHash(hash string, data []byte) (int64, error) {
+	var assetId int64
+	err := database.Db.QueryRow("SELECT id FROM `Assets` WHERE `hash` = ?", hash).Scan(&assetId)
+	if err == nil {
+		if data != nil {
+			// Rows created before sizes and types were recorded are filled in on reuse
+			contentType, kind := assetinfo.Detect(data)
+			database.Db.Exec("UPDATE `Assets` SET `size` = ? WHERE `id` = ? AND `size` = 0", len(data), assetId)
+			database.Db.Exec("UPDATE `Assets` SET `content_type` = ?, `kind` = ? WHERE `id` = ? AND `content_type` IS NULL", contentType, kind, assetId)
+		}
+		return assetId, nil
+	}
+	if err != sql.ErrNoRows {
+		return 0, err
+	}
+	if data == nil {
+		assetInsertResult, err := database.Db.Exec("INSERT INTO `Assets` (`hash`) VALUES (?)", hash)
+		if err != nil {
+			return 0, err
+		}
+		return assetInsertResult.LastInsertId()
+	}
+	contentType, kind := assetinfo.Detect(data)
+	assetInsertResult, err := database.Db.Exec("INSERT INTO `Assets` (`hash`, `size`, `content_type`, `kind`) VALUES (?, ?, ?, ?)", hash, len(data), contentType, kind)
+	if err != nil {
+		return 0, err
+	}
+	return assetInsertResult.LastInsertId()
+}
+
+// storeItem creates an item in folderId whose record is the asset named
+// recordHash and whose thumbnail, if not empty, is the asset thumbnailHash.
+// files holds the contents of newly uploaded assets keyed by hash and must
+// include the record; linked lists assets that are already stored. Every
+// asset is linked to the new item through hash-usage, in the transaction
+// that checks the quotas.
+func storeItem(folderId int, itemName string, recordHash string, thumbnailHash string, files map[string][]byte, linked []string) (int64, error) {
+	// Records keep their packdb:/// references, assethost rewrites them for
+	// whoever is downloading the record
+	if _, err := brson.Read(files[recordHash]); err != nil {
+		return 0, fmt.Errorf("failed to read main record: %w", err)
+	}
+
+	sizes := make(map[string]int64)
+	for hash, data := range files {
+		sizes[hash] = int64(len(data))
+	}
+	for _, hash := range linked {
+		var size int64
+		if err := database.Db.QueryRow("SELECT `size` FROM `Assets` WHERE `hash` = ?", hash).Scan(&size); err != nil {
+			return 0, fmt.Errorf("failed to look up asset %s: %w", hash, err)
+		}
+		sizes[hash] = size
+	}
+	// Refuse early before writing anything, the check that counts is repeated
+	// under lock below
+	if err := quota.CheckUpload(folderId, sizes); err != nil {
+		return 0, err
+	}
+
+	for hash, data := range files {
+		name := hash
+		if hash == recordHash {
+			name += ".brson"
+		}
+		if err := storage.Assets.Put(name, bytes.NewReader(data), int64(len(data))); err != nil {
+			return 0, fmt.Errorf("failed to write file %s: %w", hash, err)
+		}
+		linked = append(linked, hash)
+	}
+	// Assets left unlinked by a failed upload are removed by assetgc
+	assetIds := make([]int64, 0, len(linked))
+	for _, hash := range linked {
+		assetId, err := assetIdForHash(hash, files[hash])
+		if err != nil {
+			return 0, fmt.Errorf("failed to register asset %s: %w", hash, err)
+		}
+		assetIds = append(assetIds, assetId)
+	}
+
+	tx, err := database.Db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	if err := quota.LockInventory(tx, folderId); err != nil {
+		return 0, err
+	}
+	if err := quota.CheckUploadIn(tx, folderId, sizes); err != nil {
+		return 0, err
+	}
+	var thumbnail sql.NullString
+	if thumbnailHash != "" {
+		thumbnail = sql.NullString{String: thumbnailHash, Valid: true}
+	}
+	itemInsertResult, err := tx.Exec("INSERT INTO `Items` (`name`, `folder_id`, `url`, `thumbnail_url`) VALUES (?, ?, ?, ?)", itemName, folderId, recordHash, thumbnail)
+	if err != nil {
+		return 0, fmt.Errorf("failed to insert item into database: %w", err)
+	}
+	itemId, err := itemInsertResult.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get last insert id: %w", err)
+	}
+	for i, assetId := range assetIds {
+		if _, err := tx.Exec("INSERT INTO `hash-usage` (`asset_id`, `item_id`) VALUES (?, ?)", assetId, itemId); err != nil {
+			return 0, fmt.Errorf("failed to link asset %s: %w", linked[i], err)
+		}
+	}
+	return itemId, tx.Commit()
+}
+
 func AddListeners() {
 	// Use the same logRequest middleware from website.go for consistency
 	http.HandleFunc("/upload", logRequest(HandleUpload))
+	http.HandleFunc("/uploadRecord", logRequest(HandleUploadRecord))
 	http.HandleFunc("/addFolder", logRequest(HandleAddFolder))
+	http.HandleFunc("/renameFolder", logRequest(HandleRenameFolder))
+	http.HandleFunc("/moveFolder", logRequest(HandleMoveFolder))
+	http.HandleFunc("/removeFolder", logRequest(HandleRemoveFolder))
 	http.HandleFunc("/removeItem", logRequest(HandleRemoveItem))
+	http.HandleFunc("/renameItem", logRequest(HandleRenameItem))
+	http.HandleFunc("/moveItem", logRequest(HandleMoveItem))
+	http.HandleFunc("/copyItem", logRequest(HandleCopyItem))
+	http.HandleFunc("/export/item", logRequest(HandleExportItem))
+	http.HandleFunc("/export/folder", logRequest(HandleExportFolder))
+	http.HandleFunc("/import", logRequest(HandleImportArchive))
+	http.HandleFunc("/item/record", logRequest(HandleItemRecord))
+	http.HandleFunc("/item/tags", logRequest(HandleItemTags))
+	http.HandleFunc("/admin/quota", logRequest(HandleSetQuota))
 }
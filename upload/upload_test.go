@@ -0,0 +1,56 @@
+package upload
+
+import (
+	"os"
+	"reflect"
+	"resonite-file-provider/brson"
+	"testing"
+)
+
+const (
+	fixtureMesh    = "3333333333333333333333333333333333333333333333333333333333333333"
+	fixtureTexture = "4444444444444444444444444444444444444444444444444444444444444444"
+)
+
+// readRecordFixture loads the brson package's record fixture as it would be
+// read from an uploaded package
+func readRecordFixture(t *testing.T) map[string]interface{} {
+	t.Helper()
+	data, err := os.ReadFile("../brson/testdata/record.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc, err := brson.FromJSON(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	record, err := brson.Write(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc, err = brson.Read(record)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return doc
+}
+
+func TestReferencesOutside(t *testing.T) {
+	record := readRecordFixture(t)
+
+	// Package assets are named by bare hash, while the record refers to them
+	// with extensions
+	files := map[string][]byte{fixtureMesh: nil, fixtureTexture: nil}
+	if got := referencesOutside(record, files); len(got) != 0 {
+		t.Errorf("referencesOutside with every asset packaged = %v, want none", got)
+	}
+
+	files = map[string][]byte{fixtureMesh: nil}
+	if got, want := referencesOutside(record, files), []string{fixtureTexture}; !reflect.DeepEqual(got, want) {
+		t.Errorf("referencesOutside = %v, want %v", got, want)
+	}
+
+	if got, want := referencesOutside(record, nil), []string{fixtureMesh, fixtureTexture}; !reflect.DeepEqual(got, want) {
+		t.Errorf("referencesOutside without files = %v, want %v", got, want)
+	}
+}